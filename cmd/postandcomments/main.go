@@ -1,11 +1,26 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"flag"
+	"fmt"
 	"log"
+	"os/signal"
 	"postsandcomments/configs"
+	"postsandcomments/internal/auth"
 	"postsandcomments/internal/db"
+	"postsandcomments/internal/eventbus"
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/metrics"
+	"postsandcomments/internal/ratelimit"
 	"postsandcomments/internal/server"
+	"postsandcomments/internal/tracing"
+	"postsandcomments/internal/webhook"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
 	"github.com/spf13/viper"
 )
 
@@ -13,6 +28,7 @@ const (
 	defaultPort            = "8080"
 	InMemoryStorage string = "memory"
 	PostgreStorage  string = "postgres"
+	SQLiteStorage   string = "sqlite"
 )
 
 func main(){
@@ -25,27 +41,237 @@ func main(){
 		port = defaultPort
 	}
 
-	var dataBase db.Database
-	dbType := flag.String("storage-type", "", "Type of storage (memory or postgres)")
+	dbType := flag.String("storage-type", "", "Type of storage (memory, postgres or sqlite)")
 	flag.Parse()
 
-	switch *dbType {
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(*dbType, flag.Args()[1:]); err != nil {
+			log.Fatalf("error to migrate: %v", err)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	tracingConfig := tracing.Config{
+		Exporter:     viper.GetString("tracing_exporter"),
+		OTLPEndpoint: viper.GetString("tracing_otlp_endpoint"),
+		OTLPInsecure: viper.GetBool("tracing_otlp_insecure"),
+		SampleRatio:  viper.GetFloat64("tracing_sample_ratio"),
+	}
+	shutdownTracing, err := tracing.Setup(ctx, tracingConfig)
+	if err != nil {
+		log.Fatalf("error to set up tracing: %v", err)
+	}
+	if tracingConfig.Enabled() {
+		sql.Register("postgres-traced", tracing.WrapDriver(pq.Driver{}, "postgresql"))
+		db.PostgresDriver = "postgres-traced"
+	}
+
+	var api *server.API
+	err = server.StartServer(ctx, server.Config{
+		Port:              port,
+		ReadTimeout:       viper.GetDuration("http_read_timeout"),
+		ReadHeaderTimeout: viper.GetDuration("http_read_header_timeout"),
+		WriteTimeout:      viper.GetDuration("http_write_timeout"),
+		IdleTimeout:       viper.GetDuration("http_idle_timeout"),
+		ShutdownTimeout:   viper.GetDuration("shutdown_timeout"),
+		Limits: graph.Limits{
+			MaxDepth:      viper.GetInt("graphql_max_depth"),
+			MaxComplexity: viper.GetInt("graphql_max_complexity"),
+			Timeout:       viper.GetDuration("graphql_operation_timeout"),
+		},
+		TrustProxy: viper.GetBool("rate_limit_trust_proxy"),
+		APIKeys:    apiKeys(),
+	}, server.NewHealth(), func(ctx context.Context) (*server.API, error) {
+		var err error
+		api, err = openAPI(ctx, *dbType, metrics.New())
+		return api, err
+	})
+	if api == nil && ctx.Err() != nil {
+		log.Printf("stopped before the storage was opened: %v", err)
+		return
+	}
+	if api == nil {
+		log.Fatalf("error to start: %v", err)
+	}
+	if err != nil {
+		log.Printf("error to serve: %v", err)
+	}
+
+	// Pending webhook deliveries are saved as dead letters, so the database is closed last.
+	api.Webhooks.Close()
+	if err := api.Bus.Close(); err != nil {
+		log.Printf("error to close event bus: %v", err)
+	}
+	if err := api.Database.Close(); err != nil {
+		log.Printf("error to close database: %v", err)
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Printf("error to flush traces: %v", err)
+	}
+}
+
+// openAPI opens the storage and everything built on it. For Postgres it waits
+// for the database to come up, meanwhile the server answers probes. It gives
+// up waiting when ctx is done.
+func openAPI(ctx context.Context, dbType string, appMetrics *metrics.Metrics) (*server.API, error) {
+	var dataBase db.Database
+	var bus eventbus.Bus
+	var postgresDB *sql.DB
+
+	switch dbType {
 	case InMemoryStorage:
-		dataBase = db.NewInMemoryDB()
+		memoryDB := db.NewInMemoryDB()
+		if dataDir := viper.GetString("memory_data_dir"); dataDir != "" {
+			var err error
+			memoryDB, err = db.OpenInMemoryDB(db.DurabilityConfig{
+				Dir:              dataDir,
+				Fsync:            db.FsyncPolicy(viper.GetString("memory_fsync")),
+				FsyncInterval:    viper.GetDuration("memory_fsync_interval"),
+				SnapshotInterval: viper.GetDuration("memory_snapshot_interval"),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error to open in-memory db: %v", err)
+			}
+		}
+		memoryDB.MaxDepth = viper.GetInt("comments_max_depth")
+		dataBase = memoryDB
 	case PostgreStorage:
+		connInfo := db.PostgresConnInfo(
+			viper.GetString("postgres_host"),
+			viper.GetInt("postgres_port"),
+			viper.GetString("postgres_user"),
+			viper.GetString("postgres_password"),
+		)
 		db, err := db.NewPostgresDB(
+			ctx,
 			viper.GetString("postgres_host"),
 			viper.GetInt("postgres_port"), 
 			viper.GetString("postgres_user"), 
 			viper.GetString("postgres_password"),
 		)
 		if err != nil {
-			log.Fatalf("error to open postgresql: %v", err)
+			return nil, fmt.Errorf("error to open postgresql: %v", err)
 		}
+		db.MaxDepth = viper.GetInt("comments_max_depth")
 		dataBase = db
+		postgresDB = db.DB
+		if err := appMetrics.RegisterDBStats("postgres", db.DB); err != nil {
+			return nil, fmt.Errorf("error to register connection pool metrics: %v", err)
+		}
+
+		// Replicas share the database, so subscribers of one see comments created on another.
+		bus, err = eventbus.NewPostgresBus(connInfo, db.DB, db)
+		if err != nil {
+			return nil, fmt.Errorf("error to start postgres event bus: %v", err)
+		}
+	case SQLiteStorage:
+		sqliteDB, err := db.NewSQLiteDB(viper.GetString("sqlite_path"))
+		if err != nil {
+			return nil, fmt.Errorf("error to open sqlite: %v", err)
+		}
+		sqliteDB.MaxDepth = viper.GetInt("comments_max_depth")
+		dataBase = sqliteDB
 	default:
-		log.Fatalf("invalid storage type. Use --storage-type either memory or postgres.")
+		return nil, fmt.Errorf("invalid storage type. Use --storage-type memory, postgres or sqlite.")
 	}
+	dataBase = tracing.TraceDatabase(dbType, appMetrics.InstrumentDatabase(dbType, dataBase))
 
-	server.StartServer(port, dataBase)
-}
\ No newline at end of file
+	authenticator, err := auth.NewAuthenticator(auth.Config{
+		HS256Secret:        viper.GetString("jwt_hs256_secret"),
+		RS256PublicKeyFile: viper.GetString("jwt_rs256_public_key_file"),
+		JWKSFile:           viper.GetString("jwt_jwks_file"),
+		Issuer:             viper.GetString("jwt_issuer"),
+		Audience:           viper.GetString("jwt_audience"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error to configure authentication: %v", err)
+	}
+
+	if bus == nil {
+		bus = eventbus.NewLocalBus()
+	}
+	subscriptions, err := graph.NewSubscriptionManager(graph.SubscriptionConfig{
+		QueueSize: viper.GetInt("subscription_queue_size"),
+		Policy:    graph.OverflowPolicy(viper.GetString("subscription_overflow_policy")),
+		Bus:       bus,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error to configure subscriptions: %v", err)
+	}
+	if err := appMetrics.RegisterSubscriptions(subscriptions); err != nil {
+		return nil, fmt.Errorf("error to register subscription metrics: %v", err)
+	}
+
+	rateLimits, err := newRateLimits(postgresDB)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := webhook.NewDispatcher(dataBase, webhook.Config{
+		Workers:        viper.GetInt("webhook_workers"),
+		QueueSize:      viper.GetInt("webhook_queue_size"),
+		MaxAttempts:    viper.GetInt("webhook_max_attempts"),
+		InitialBackoff: viper.GetDuration("webhook_initial_backoff"),
+		MaxBackoff:     viper.GetDuration("webhook_max_backoff"),
+		Timeout:        viper.GetDuration("webhook_timeout"),
+	})
+
+	return &server.API{
+		Database:      dataBase,
+		Authenticator: authenticator,
+		Subscriptions: subscriptions,
+		Webhooks:      webhooks,
+		Bus:           bus,
+		Metrics:       appMetrics,
+		RateLimits:    rateLimits,
+	}, nil
+}
+
+// apiKeys turns rate_limit_api_keys, client ids to keys, into keys to client ids.
+func apiKeys() map[string]string {
+	keys := make(map[string]string)
+	for id, key := range viper.GetStringMapString("rate_limit_api_keys") {
+		if key != "" {
+			keys[key] = id
+		}
+	}
+	return keys
+}
+
+// newRateLimits keeps the buckets in Postgres if rate_limit_store is postgres,
+// which needs the Postgres storage.
+func newRateLimits(postgresDB *sql.DB) (*ratelimit.Policy, error) {
+	var limiter ratelimit.Limiter
+	switch viper.GetString("rate_limit_store") {
+	case "", "memory":
+		limiter = ratelimit.NewMemoryLimiter()
+	case "postgres":
+		if postgresDB == nil {
+			return nil, fmt.Errorf("rate_limit_store postgres needs --storage-type postgres")
+		}
+		limiter = ratelimit.NewPostgresLimiter(postgresDB)
+	default:
+		return nil, fmt.Errorf("invalid rate_limit_store. Use memory or postgres.")
+	}
+
+	limit := func(name string) ratelimit.Limit {
+		return ratelimit.Limit{
+			Requests: viper.GetInt("rate_limits." + name + ".requests"),
+			Per:      viper.GetDuration("rate_limits." + name + ".per"),
+		}
+	}
+	return &ratelimit.Policy{
+		Limiter: limiter,
+		Limits: map[string]ratelimit.Limit{
+			ratelimit.CreatePost:    limit("create_post"),
+			ratelimit.CreateComment: limit("create_comment"),
+			ratelimit.Subscription:  limit("subscription"),
+		},
+	}, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"postsandcomments/internal/db"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const migrateUsage = "usage: postandcomments --storage-type postgres|sqlite migrate up|down [steps]|status"
+
+func runMigrate(dbType string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+
+	ctx := context.Background()
+	migrator, err := newMigrator(ctx, dbType)
+	if err != nil {
+		return err
+	}
+	defer migrator.DB.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("no migrations to apply")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Println("no migrations to revert")
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+}
+
+func newMigrator(ctx context.Context, dbType string) (*db.Migrator, error) {
+	switch dbType {
+	case PostgreStorage:
+		conn, err := db.OpenPostgres(
+			ctx,
+			viper.GetString("postgres_host"),
+			viper.GetInt("postgres_port"),
+			viper.GetString("postgres_user"),
+			viper.GetString("postgres_password"),
+		)
+		if err != nil {
+			return nil, err
+		}
+		migrator, err := db.NewPostgresMigrator(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return migrator, nil
+	case SQLiteStorage:
+		conn, err := db.OpenSQLite(viper.GetString("sqlite_path"))
+		if err != nil {
+			return nil, err
+		}
+		migrator, err := db.NewSQLiteMigrator(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return migrator, nil
+	default:
+		return nil, fmt.Errorf("migrations are supported only for --storage-type postgres or sqlite")
+	}
+}
@@ -5,5 +5,9 @@ import "github.com/spf13/viper"
 func InitConfig() error {
 	viper.AddConfigPath("configs")
 	viper.SetConfigName("config")
+	// Secrets are better kept out of the config file.
+	if err := viper.BindEnv("jwt_hs256_secret", "JWT_HS256_SECRET"); err != nil {
+		return err
+	}
 	return viper.ReadInConfig()
-}
\ No newline at end of file
+}
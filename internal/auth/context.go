@@ -0,0 +1,38 @@
+package auth
+
+import "context"
+
+// AdminRole is the role in the "roles" claim that allows managing webhooks.
+const AdminRole = "admin"
+
+type User struct {
+	ID    string
+	Name  string
+	Roles []string
+}
+
+// HasRole reports whether the user has the role, nil users have no roles.
+func (u *User) HasRole(role string) bool {
+	if u == nil {
+		return false
+	}
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// WithUser returns a copy of ctx that carries the user making the request.
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// ForContext returns the user making the request or nil for anonymous requests.
+func ForContext(ctx context.Context) *User {
+	user, _ := ctx.Value(contextKey{}).(*User)
+	return user
+}
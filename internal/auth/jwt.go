@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type Config struct {
+	HS256Secret        string
+	RS256PublicKeyFile string
+	JWKSFile           string
+	Issuer             string
+	Audience           string
+}
+
+// Authenticator validates HS256 and RS256 bearer tokens. Keys are looked up by the
+// "kid" header of the token, keys from the config itself have an empty kid.
+type Authenticator struct {
+	hmacKeys map[string][]byte
+	rsaKeys  map[string]*rsa.PublicKey
+	parser   *jwt.Parser
+}
+
+type claims struct {
+	jwt.RegisteredClaims
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Roles             []string `json:"roles"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+func NewAuthenticator(cfg Config) (*Authenticator, error) {
+	a := &Authenticator{
+		hmacKeys: make(map[string][]byte),
+		rsaKeys:  make(map[string]*rsa.PublicKey),
+	}
+
+	if cfg.HS256Secret != "" {
+		a.hmacKeys[""] = []byte(cfg.HS256Secret)
+	}
+
+	if cfg.RS256PublicKeyFile != "" {
+		pem, err := os.ReadFile(cfg.RS256PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error to read rs256 public key: %v", err)
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("error to parse rs256 public key: %v", err)
+		}
+		a.rsaKeys[""] = key
+	}
+
+	if cfg.JWKSFile != "" {
+		if err := a.loadJWKS(cfg.JWKSFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(a.hmacKeys) == 0 && len(a.rsaKeys) == 0 {
+		return nil, fmt.Errorf("no keys configured for jwt authentication")
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+	a.parser = jwt.NewParser(options...)
+
+	return a, nil
+}
+
+// Authenticate validates the token and returns the user it was issued for.
+// The "Bearer " prefix is optional.
+func (a *Authenticator) Authenticate(token string) (*User, error) {
+	token = strings.TrimSpace(token)
+	if len(token) > len(bearerPrefix) && strings.EqualFold(token[:len(bearerPrefix)], bearerPrefix) {
+		token = strings.TrimSpace(token[len(bearerPrefix):])
+	}
+
+	var c claims
+	_, err := a.parser.ParseWithClaims(token, &c, a.key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+	if c.Subject == "" {
+		return nil, fmt.Errorf("invalid token: no subject")
+	}
+
+	user := &User{ID: c.Subject, Name: c.Name, Roles: c.Roles}
+	if user.Name == "" {
+		user.Name = c.PreferredUsername
+	}
+	if user.Name == "" {
+		user.Name = c.Subject
+	}
+
+	return user, nil
+}
+
+func (a *Authenticator) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return lookupKey(a.hmacKeys, kid)
+	case jwt.SigningMethodRS256.Alg():
+		return lookupKey(a.rsaKeys, kid)
+	default:
+		return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+	}
+}
+
+// lookupKey finds the key by kid. A token without kid may be verified only
+// if exactly one key of its type is configured.
+func lookupKey[K any](keys map[string]K, kid string) (K, error) {
+	if key, exists := keys[kid]; exists {
+		return key, nil
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+
+	var empty K
+	return empty, fmt.Errorf("no key with kid %q", kid)
+}
+
+func (a *Authenticator) loadJWKS(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error to read jwks file: %v", err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return fmt.Errorf("error to parse jwks file: %v", err)
+	}
+
+	for _, key := range set.Keys {
+		switch key.Kty {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(key.N)
+			if err != nil {
+				return fmt.Errorf("error to decode modulus of key %q: %v", key.Kid, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(key.E)
+			if err != nil {
+				return fmt.Errorf("error to decode exponent of key %q: %v", key.Kid, err)
+			}
+			a.rsaKeys[key.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(key.K)
+			if err != nil {
+				return fmt.Errorf("error to decode secret of key %q: %v", key.Kid, err)
+			}
+			a.hmacKeys[key.Kid] = secret
+		default:
+			return fmt.Errorf("unsupported key type %q in jwks file", key.Kty)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/auth"
+
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSecret = "test_secret"
+
+func signHS256(t *testing.T, secret string, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return token
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":  "test_user_id",
+		"name": "Test User",
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestAuthenticateHS256(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator(auth.Config{HS256Secret: testSecret})
+	assert.NoError(t, err)
+
+	user, err := authenticator.Authenticate("Bearer " + signHS256(t, testSecret, validClaims()))
+	assert.NoError(t, err)
+	assert.Equal(t, &auth.User{ID: "test_user_id", Name: "Test User"}, user)
+}
+
+func TestAuthenticateRoles(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator(auth.Config{HS256Secret: testSecret})
+	assert.NoError(t, err)
+
+	claims := validClaims()
+	claims["roles"] = []string{"moderator", auth.AdminRole}
+	user, err := authenticator.Authenticate(signHS256(t, testSecret, claims))
+	assert.NoError(t, err)
+	assert.True(t, user.HasRole(auth.AdminRole))
+	assert.False(t, user.HasRole("owner"))
+
+	user, err = authenticator.Authenticate(signHS256(t, testSecret, validClaims()))
+	assert.NoError(t, err)
+	assert.False(t, user.HasRole(auth.AdminRole))
+}
+
+func TestAuthenticateRejectsInvalidTokens(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator(auth.Config{HS256Secret: testSecret, Issuer: "test_issuer"})
+	assert.NoError(t, err)
+
+	claims := validClaims()
+	claims["iss"] = "test_issuer"
+	_, err = authenticator.Authenticate(signHS256(t, testSecret, claims))
+	assert.NoError(t, err)
+
+	_, err = authenticator.Authenticate(signHS256(t, "wrong_secret", claims))
+	assert.Error(t, err)
+
+	expired := validClaims()
+	expired["iss"] = "test_issuer"
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	_, err = authenticator.Authenticate(signHS256(t, testSecret, expired))
+	assert.Error(t, err)
+
+	_, err = authenticator.Authenticate(signHS256(t, testSecret, validClaims()))
+	assert.Error(t, err)
+
+	_, err = authenticator.Authenticate("not a token")
+	assert.Error(t, err)
+}
+
+func TestAuthenticateRS256FromJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	jwks, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test_kid",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, jwks, 0o600))
+
+	authenticator, err := auth.NewAuthenticator(auth.Config{JWKSFile: path})
+	assert.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims())
+	token.Header["kid"] = "test_kid"
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+
+	user, err := authenticator.Authenticate(signed)
+	assert.NoError(t, err)
+	assert.Equal(t, "test_user_id", user.ID)
+
+	_, err = authenticator.Authenticate(signHS256(t, testSecret, validClaims()))
+	assert.Error(t, err)
+}
+
+func TestNewAuthenticatorWithoutKeys(t *testing.T) {
+	_, err := auth.NewAuthenticator(auth.Config{})
+	assert.Error(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator(auth.Config{HS256Secret: testSecret})
+	assert.NoError(t, err)
+
+	var user *auth.User
+	handler := authenticator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = auth.ForContext(r.Context())
+	}))
+
+	request := httptest.NewRequest(http.MethodPost, "/query", nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Nil(t, user)
+
+	request = httptest.NewRequest(http.MethodPost, "/query", nil)
+	request.Header.Set("Authorization", "Bearer "+signHS256(t, testSecret, validClaims()))
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "test_user_id", user.ID)
+
+	user = nil
+	request = httptest.NewRequest(http.MethodPost, "/query", nil)
+	request.Header.Set("Authorization", "Bearer "+signHS256(t, "wrong_secret", validClaims()))
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+	assert.Nil(t, user)
+}
+
+func TestWebsocketInit(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator(auth.Config{HS256Secret: testSecret})
+	assert.NoError(t, err)
+
+	ctx, _, err := authenticator.WebsocketInit(context.Background(), transport.InitPayload{
+		"authToken": signHS256(t, testSecret, validClaims()),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "test_user_id", auth.ForContext(ctx).ID)
+
+	ctx, _, err = authenticator.WebsocketInit(context.Background(), transport.InitPayload{})
+	assert.NoError(t, err)
+	assert.Nil(t, auth.ForContext(ctx))
+
+	_, _, err = authenticator.WebsocketInit(context.Background(), transport.InitPayload{
+		"Authorization": "Bearer " + signHS256(t, "wrong_secret", validClaims()),
+	})
+	assert.Error(t, err)
+}
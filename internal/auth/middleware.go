@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const bearerPrefix = "Bearer "
+
+// Middleware puts the user from the Authorization header into the request context.
+// Requests without the header stay anonymous, requests with an invalid token are rejected.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := a.Authenticate(header)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"errors":[{"message":"invalid token","extensions":{"code":"UNAUTHENTICATED"}}]}`)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+	})
+}
+
+// WebsocketInit authenticates subscriptions by the token passed in the connection_init
+// payload as "Authorization" or "authToken", since browsers can't set headers for websockets.
+func (a *Authenticator) WebsocketInit(ctx context.Context, payload transport.InitPayload) (context.Context, *transport.InitPayload, error) {
+	token := payload.Authorization()
+	if token == "" {
+		token = payload.GetString("authToken")
+	}
+	if token == "" {
+		return ctx, nil, nil
+	}
+
+	user, err := a.Authenticate(token)
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	return WithUser(ctx, user), nil, nil
+}
+
+// RequireUserForMutations rejects mutations made by anonymous callers.
+func RequireUserForMutations(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	operation := graphql.GetOperationContext(ctx).Operation
+	if operation != nil && operation.Operation == ast.Mutation && ForContext(ctx) == nil {
+		return graphql.OneShot(&graphql.Response{
+			Errors: gqlerror.List{{
+				Message:    "authentication required",
+				Extensions: map[string]interface{}{"code": "UNAUTHENTICATED"},
+			}},
+		})
+	}
+
+	return next(ctx)
+}
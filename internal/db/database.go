@@ -2,13 +2,104 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"postsandcomments/internal/graph/model"
+	"time"
 )
 
+// DefaultMaxDepth is how many levels of replies are loaded into Children
+// of returned comments when the backend has no MaxDepth set.
+const DefaultMaxDepth = 5
+
+// DeletedCommentBody replaces the body of a deleted comment that still has replies.
+const DeletedCommentBody = "[deleted]"
+
+// PostQuery selects a page of posts. Filters that are nil match every post.
+type PostQuery struct {
+	OrderBy       model.PostOrder
+	AuthorID      *string
+	AllowComments *bool
+	CreatedAfter  *time.Time
+	First         int
+	// After is the last post of the previous page, only ID and the fields
+	// OrderBy sorts by are used.
+	After *model.Post
+}
+
 type Database interface {
+	// CreatePost sets CommentCount of the post to zero and LastActivityAt to CreatedAt.
 	CreatePost(ctx context.Context, post *model.Post) error
-	GetPosts(ctx context.Context) ([]*model.Post, error)
-	GetPostById(ctx context.Context, id string, limit *int, offset *int) (*model.Post, error)
+	// GetPosts returns up to query.First posts in query.OrderBy order. Posts with
+	// the same sort key are ordered by CreatedAt and then by ID, newest first for
+	// all orders but OLDEST.
+	GetPosts(ctx context.Context, query PostQuery) ([]*model.Post, error)
+	GetPostById(ctx context.Context, id string) (*model.Post, error)
+	UpdatePost(ctx context.Context, post *model.Post) error
+	DeletePost(ctx context.Context, id string) error
+	// CreateComment counts the comment in CommentCount of the post and moves
+	// its LastActivityAt to CreatedAt of the comment. Comments of a post become
+	// visible in Seq order, so cursors over Seq never skip a comment.
 	CreateComment(ctx context.Context, post *model.Post, comment *model.Comment) error
+	// GetCommentById and GetComments fill Children of returned comments
+	// with replies at most MaxDepth levels deep.
 	GetCommentById(ctx context.Context, id string) (*model.Comment, error)
+	// GetComments returns up to first replies to the parent comment, or top-level comments
+	// of the post if parentID is nil, in the order they were created. If after is not nil,
+	// only comments created after the comment with this Seq are returned. Unknown posts
+	// and parents have no comments.
+	GetComments(ctx context.Context, postID string, parentID *string, first int, after *int64) ([]*model.Comment, error)
+	// GetCommentsSince returns up to limit comments of the post at any depth with Seq
+	// greater than afterSeq, ordered by Seq. Children of returned comments are not filled.
+	GetCommentsSince(ctx context.Context, postID string, afterSeq int64, limit int) ([]*model.Comment, error)
+	UpdateComment(ctx context.Context, comment *model.Comment) error
+	// DeleteComment removes the comment, or turns it into a tombstone
+	// with DeletedCommentBody if it has replies, so the thread stays intact.
+	// Tombstones left without replies are removed, up the thread.
+	// Either way it is no longer counted in CommentCount of the post.
+	DeleteComment(ctx context.Context, id string) error
+	SaveUser(ctx context.Context, user *model.User) error
+	GetUserById(ctx context.Context, id string) (*model.User, error)
+	// GetUsersByIds returns the users with the ids in any order, ids without a
+	// user are left out.
+	GetUsersByIds(ctx context.Context, ids []string) ([]*model.User, error)
+	CreateWebhook(ctx context.Context, webhook *model.Webhook) error
+	GetWebhooks(ctx context.Context) ([]*model.Webhook, error)
+	// DeleteWebhook removes the webhook together with its dead letters.
+	DeleteWebhook(ctx context.Context, id string) error
+	// CreateDeadLetter saves a webhook delivery that failed after all attempts.
+	CreateDeadLetter(ctx context.Context, delivery *model.WebhookDelivery) error
+	// GetDeadLetters returns up to limit failed deliveries, latest first, only of
+	// the webhook if webhookID is not nil.
+	GetDeadLetters(ctx context.Context, webhookID *string, limit int) ([]*model.WebhookDelivery, error)
+	// Ping reports whether the database can serve requests.
+	Ping(ctx context.Context) error
+	// Close releases the connections or files of the database, it must not be used afterwards.
+	Close() error
+}
+
+func maxDepth(depth int) int {
+	if depth <= 0 {
+		return DefaultMaxDepth
+	}
+	return depth
+}
+
+// inUTC scans a timestamp into t in UTC, so times read back are equal to the
+// written ones whatever zone the driver returns them in.
+func inUTC(t *time.Time) sql.Scanner {
+	return utcScanner{t}
+}
+
+type utcScanner struct {
+	t *time.Time
+}
+
+func (s utcScanner) Scan(value interface{}) error {
+	t, ok := value.(time.Time)
+	if !ok {
+		return fmt.Errorf("unsupported timestamp type %T", value)
+	}
+	*s.t = t.UTC()
+	return nil
 }
@@ -0,0 +1,932 @@
+// Package dbtest contains the conformance suite every db.Database backend has to pass.
+package dbtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/graph/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RunConformance runs the suite as subtests of t. newDB is called for every
+// subtest and must return an empty database with the default MaxDepth.
+func RunConformance(t *testing.T, newDB func() db.Database) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, database db.Database)
+	}{
+		{"CreateAndGetPost", testCreateAndGetPost},
+		{"GetPosts", testGetPosts},
+		{"GetPostsOrder", testGetPostsOrder},
+		{"GetPostsFilters", testGetPostsFilters},
+		{"PostCounters", testPostCounters},
+		{"UpdatePost", testUpdatePost},
+		{"DeletePost", testDeletePost},
+		{"CreateAndGetComment", testCreateAndGetComment},
+		{"CreateCommentWithInvalidParent", testCreateCommentWithInvalidParent},
+		{"GetCommentsOrder", testGetCommentsOrder},
+		{"GetCommentsPagination", testGetCommentsPagination},
+		{"GetCommentsWithMissingIds", testGetCommentsWithMissingIds},
+		{"GetCommentsSince", testGetCommentsSince},
+		{"DeepNesting", testDeepNesting},
+		{"UpdateComment", testUpdateComment},
+		{"DeleteComment", testDeleteComment},
+		{"DeleteCommentRemovesEmptyTombstones", testDeleteCommentRemovesEmptyTombstones},
+		{"ConcurrentDeletesRemoveEmptyTombstones", testConcurrentDeletesRemoveEmptyTombstones},
+		{"Users", testUsers},
+		{"Webhooks", testWebhooks},
+		{"DeadLetters", testDeadLetters},
+		{"ReturnedValuesAreCopies", testReturnedValuesAreCopies},
+		{"ConcurrentComments", testConcurrentComments},
+		{"CommentsVisibleInSeqOrder", testCommentsVisibleInSeqOrder},
+		{"Ping", testPing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newDB())
+		})
+	}
+}
+
+func newPost(allowComments bool) *model.Post {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	return &model.Post{
+		ID:            uuid.New().String(),
+		Title:         "Test Post",
+		Body:          "Test body",
+		AllowComments: allowComments,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+func newComment(post *model.Post, parent *model.Comment) *model.Comment {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	comment := &model.Comment{
+		ID:        uuid.New().String(),
+		PostID:    post.ID,
+		Body:      "Test Comment",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if parent != nil {
+		comment.ParentID = &parent.ID
+	}
+	return comment
+}
+
+func createPost(t *testing.T, database db.Database) *model.Post {
+	post := newPost(true)
+	require.NoError(t, database.CreatePost(context.Background(), post))
+	return post
+}
+
+func createComment(t *testing.T, database db.Database, post *model.Post, parent *model.Comment) *model.Comment {
+	comment := newComment(post, parent)
+	require.NoError(t, database.CreateComment(context.Background(), post, comment))
+	return comment
+}
+
+func commentIDs(comments []*model.Comment) []string {
+	ids := make([]string, 0, len(comments))
+	for _, comment := range comments {
+		ids = append(ids, comment.ID)
+	}
+	return ids
+}
+
+func testCreateAndGetPost(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+
+	fetchedPost, err := database.GetPostById(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, post, fetchedPost)
+
+	assert.Error(t, database.CreatePost(ctx, post))
+
+	_, err = database.GetPostById(ctx, uuid.New().String())
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
+
+func testGetPosts(t *testing.T, database db.Database) {
+	ctx := context.Background()
+
+	posts, err := database.GetPosts(ctx, db.PostQuery{First: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, posts)
+
+	post1 := createPost(t, database)
+	post2 := newPost(false)
+	require.NoError(t, database.CreatePost(ctx, post2))
+
+	posts, err = database.GetPosts(ctx, db.PostQuery{First: 10})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*model.Post{post1, post2}, posts)
+}
+
+// createPostAt creates a post created at the time, so orders do not depend on
+// how fast the test runs.
+func createPostAt(t *testing.T, database db.Database, createdAt time.Time) *model.Post {
+	post := newPost(true)
+	post.CreatedAt = createdAt
+	require.NoError(t, database.CreatePost(context.Background(), post))
+	return post
+}
+
+func createCommentAt(t *testing.T, database db.Database, post *model.Post, createdAt time.Time) *model.Comment {
+	comment := newComment(post, nil)
+	comment.CreatedAt = createdAt
+	require.NoError(t, database.CreateComment(context.Background(), post, comment))
+	return comment
+}
+
+func postIDs(posts []*model.Post) []string {
+	ids := make([]string, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+	return ids
+}
+
+// getAllPosts pages through the posts two at a time.
+func getAllPosts(t *testing.T, database db.Database, query db.PostQuery) []string {
+	query.First = 2
+	var ids []string
+	for {
+		posts, err := database.GetPosts(context.Background(), query)
+		require.NoError(t, err)
+		ids = append(ids, postIDs(posts)...)
+		if len(posts) < query.First {
+			return ids
+		}
+		query.After = posts[len(posts)-1]
+	}
+}
+
+func testGetPostsOrder(t *testing.T, database db.Database) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	first := createPostAt(t, database, now.Add(-3*time.Hour))
+	second := createPostAt(t, database, now.Add(-2*time.Hour))
+	third := createPostAt(t, database, now.Add(-time.Hour))
+	// Posts created at the same time are ordered by ID.
+	sameTime := createPostAt(t, database, now.Add(-time.Hour))
+	thirdAndSameTime := []string{third.ID, sameTime.ID}
+	if third.ID > sameTime.ID {
+		thirdAndSameTime = []string{sameTime.ID, third.ID}
+	}
+
+	createCommentAt(t, database, first, now.Add(-30*time.Minute))
+	createCommentAt(t, database, first, now.Add(-20*time.Minute))
+	createCommentAt(t, database, second, now.Add(-10*time.Minute))
+
+	tests := []struct {
+		order model.PostOrder
+		want  []string
+	}{
+		{model.PostOrderNewest, []string{thirdAndSameTime[1], thirdAndSameTime[0], second.ID, first.ID}},
+		{model.PostOrderOldest, []string{first.ID, second.ID, thirdAndSameTime[0], thirdAndSameTime[1]}},
+		{model.PostOrderMostCommented, []string{first.ID, second.ID, thirdAndSameTime[1], thirdAndSameTime[0]}},
+		{model.PostOrderRecentActivity, []string{second.ID, first.ID, thirdAndSameTime[1], thirdAndSameTime[0]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order.String(), func(t *testing.T) {
+			posts, err := database.GetPosts(context.Background(), db.PostQuery{OrderBy: tt.order, First: 10})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, postIDs(posts))
+
+			assert.Equal(t, tt.want, getAllPosts(t, database, db.PostQuery{OrderBy: tt.order}))
+		})
+	}
+}
+
+func testGetPostsFilters(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	author := &model.User{ID: uuid.New().String(), Name: "Test User"}
+	require.NoError(t, database.SaveUser(ctx, author))
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	old := createPostAt(t, database, now.Add(-2*time.Hour))
+	closed := newPost(false)
+	closed.CreatedAt = now.Add(-time.Hour)
+	require.NoError(t, database.CreatePost(ctx, closed))
+	authored := newPost(true)
+	authored.AuthorID = &author.ID
+	authored.CreatedAt = now
+	require.NoError(t, database.CreatePost(ctx, authored))
+
+	allowComments, createdAfter := true, now.Add(-90*time.Minute)
+	tests := []struct {
+		name  string
+		query db.PostQuery
+		want  []string
+	}{
+		{"Author", db.PostQuery{AuthorID: &author.ID}, []string{authored.ID}},
+		{"AllowComments", db.PostQuery{AllowComments: &allowComments}, []string{authored.ID, old.ID}},
+		{"CreatedAfter", db.PostQuery{CreatedAfter: &createdAfter}, []string{authored.ID, closed.ID}},
+		{"CreatedAfterInOtherZone", db.PostQuery{CreatedAfter: ptr(createdAfter.In(time.FixedZone("UTC+3", 3*60*60)))}, []string{authored.ID, closed.ID}},
+		{"All", db.PostQuery{AllowComments: &allowComments, CreatedAfter: &createdAfter}, []string{authored.ID}},
+		{"UnknownAuthor", db.PostQuery{AuthorID: ptr(uuid.New().String())}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, getAllPosts(t, database, tt.query))
+		})
+	}
+}
+
+func testPostCounters(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	post := createPostAt(t, database, now.Add(-time.Hour))
+	assert.Equal(t, 0, post.CommentCount)
+	assert.Equal(t, post.CreatedAt, post.LastActivityAt)
+
+	comment := createCommentAt(t, database, post, now)
+	reply := newComment(post, comment)
+	reply.CreatedAt = now.Add(time.Minute)
+	require.NoError(t, database.CreateComment(ctx, post, reply))
+	// Activity never moves back, even if a comment comes with an earlier time.
+	createCommentAt(t, database, post, now.Add(-time.Minute))
+
+	fetchedPost, err := database.GetPostById(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, fetchedPost.CommentCount)
+	assert.Equal(t, reply.CreatedAt, fetchedPost.LastActivityAt)
+	assert.Equal(t, post.CreatedAt, fetchedPost.CreatedAt)
+
+	fetchedComment, err := database.GetCommentById(ctx, reply.ID)
+	require.NoError(t, err)
+	assert.Equal(t, reply.CreatedAt, fetchedComment.CreatedAt)
+
+	// The tombstone of the comment is not counted, deleting it again changes nothing.
+	require.NoError(t, database.DeleteComment(ctx, comment.ID))
+	fetchedPost, err = database.GetPostById(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, fetchedPost.CommentCount)
+
+	require.NoError(t, database.DeleteComment(ctx, reply.ID))
+	fetchedPost, err = database.GetPostById(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, fetchedPost.CommentCount)
+	assert.Equal(t, reply.CreatedAt, fetchedPost.LastActivityAt)
+}
+
+func testUpdatePost(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+
+	post.Title = "Updated Post"
+	post.Body = "Updated body"
+	post.AllowComments = false
+	post.UpdatedAt = post.CreatedAt.Add(time.Hour)
+	assert.NoError(t, database.UpdatePost(ctx, post))
+
+	fetchedPost, err := database.GetPostById(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, post, fetchedPost)
+
+	assert.ErrorIs(t, database.UpdatePost(ctx, newPost(true)), db.ErrNotFound)
+}
+
+func testDeletePost(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+	otherPost := createPost(t, database)
+	comment := createComment(t, database, post, nil)
+	reply := createComment(t, database, post, comment)
+	otherComment := createComment(t, database, otherPost, nil)
+
+	assert.NoError(t, database.DeletePost(ctx, post.ID))
+
+	_, err := database.GetPostById(ctx, post.ID)
+	assert.Error(t, err)
+	_, err = database.GetCommentById(ctx, comment.ID)
+	assert.Error(t, err)
+	_, err = database.GetCommentById(ctx, reply.ID)
+	assert.Error(t, err)
+	_, err = database.GetCommentById(ctx, otherComment.ID)
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, database.DeletePost(ctx, post.ID), db.ErrNotFound)
+}
+
+func testCreateAndGetComment(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+	comment := createComment(t, database, post, nil)
+	reply := createComment(t, database, post, comment)
+	replyToReply := createComment(t, database, post, reply)
+
+	assert.Less(t, comment.Seq, reply.Seq)
+	assert.Less(t, reply.Seq, replyToReply.Seq)
+
+	fetchedComment, err := database.GetCommentById(ctx, replyToReply.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, replyToReply, fetchedComment)
+
+	fetchedComment, err = database.GetCommentById(ctx, comment.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, comment.ID, fetchedComment.ID)
+	assert.Equal(t, comment.Body, fetchedComment.Body)
+	assert.Nil(t, fetchedComment.ParentID)
+	assert.False(t, fetchedComment.Deleted)
+	assert.Equal(t, []string{reply.ID}, commentIDs(fetchedComment.Children))
+
+	_, err = database.GetCommentById(ctx, uuid.New().String())
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	missingPost := newPost(true)
+	assert.ErrorIs(t, database.CreateComment(ctx, missingPost, newComment(missingPost, nil)), db.ErrNotFound)
+}
+
+func testCreateCommentWithInvalidParent(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+	otherPost := createPost(t, database)
+	otherComment := createComment(t, database, otherPost, nil)
+
+	missingParent := newComment(post, nil)
+	assert.ErrorIs(t, database.CreateComment(ctx, post, newComment(post, missingParent)), db.ErrNotFound)
+
+	assert.ErrorIs(t, database.CreateComment(ctx, post, newComment(post, otherComment)), db.ErrParentPostMismatch)
+
+	comments, err := database.GetComments(ctx, post.ID, nil, 10, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+}
+
+func testGetCommentsOrder(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+
+	first := createComment(t, database, post, nil)
+	firstReply := createComment(t, database, post, first)
+	second := createComment(t, database, post, nil)
+	secondReply := createComment(t, database, post, first)
+	third := createComment(t, database, post, nil)
+
+	comments, err := database.GetComments(ctx, post.ID, nil, 10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{first.ID, second.ID, third.ID}, commentIDs(comments))
+	assert.Equal(t, []string{firstReply.ID, secondReply.ID}, commentIDs(comments[0].Children))
+
+	replies, err := database.GetComments(ctx, post.ID, &first.ID, 10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Comment{firstReply, secondReply}, replies)
+}
+
+func testGetCommentsPagination(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+
+	created := make([]*model.Comment, 0)
+	for i := 0; i < 5; i++ {
+		created = append(created, createComment(t, database, post, nil))
+	}
+
+	comments, err := database.GetComments(ctx, post.ID, nil, 0, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+
+	comments, err = database.GetComments(ctx, post.ID, nil, 2, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, commentIDs(created[:2]), commentIDs(comments))
+
+	after := comments[len(comments)-1].Seq
+	comments, err = database.GetComments(ctx, post.ID, nil, 2, &after)
+	assert.NoError(t, err)
+	assert.Equal(t, commentIDs(created[2:4]), commentIDs(comments))
+
+	after = comments[len(comments)-1].Seq
+	comments, err = database.GetComments(ctx, post.ID, nil, 10, &after)
+	assert.NoError(t, err)
+	assert.Equal(t, commentIDs(created[4:]), commentIDs(comments))
+
+	after = created[4].Seq
+	comments, err = database.GetComments(ctx, post.ID, nil, 10, &after)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+
+	// New comments go to the end and don't shift pages that were already read.
+	after = created[1].Seq
+	createComment(t, database, post, nil)
+	comments, err = database.GetComments(ctx, post.ID, nil, 2, &after)
+	assert.NoError(t, err)
+	assert.Equal(t, commentIDs(created[2:4]), commentIDs(comments))
+
+	// A cursor stays valid after the comment it points to is deleted.
+	require.NoError(t, database.DeleteComment(ctx, created[2].ID))
+	after = created[2].Seq
+	comments, err = database.GetComments(ctx, post.ID, nil, 1, &after)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{created[3].ID}, commentIDs(comments))
+}
+
+func testGetCommentsWithMissingIds(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+	otherPost := createPost(t, database)
+	otherComment := createComment(t, database, otherPost, nil)
+	createComment(t, database, otherPost, otherComment)
+
+	comments, err := database.GetComments(ctx, uuid.New().String(), nil, 10, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+
+	missingID := uuid.New().String()
+	comments, err = database.GetComments(ctx, post.ID, &missingID, 10, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+
+	comments, err = database.GetComments(ctx, post.ID, &otherComment.ID, 10, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+
+	// Ids that are not UUIDs can't belong to any post or comment.
+	invalidID := "not-a-uuid"
+	comments, err = database.GetComments(ctx, invalidID, nil, 10, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+
+	comments, err = database.GetComments(ctx, post.ID, &invalidID, 10, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+}
+
+func testGetCommentsSince(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+	otherPost := createPost(t, database)
+
+	first := createComment(t, database, post, nil)
+	firstReply := createComment(t, database, post, first)
+	createComment(t, database, otherPost, nil)
+	second := createComment(t, database, post, nil)
+	nestedReply := createComment(t, database, post, firstReply)
+
+	comments, err := database.GetCommentsSince(ctx, post.ID, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{first.ID, firstReply.ID, second.ID, nestedReply.ID}, commentIDs(comments))
+	assert.Empty(t, comments[0].Children)
+
+	comments, err = database.GetCommentsSince(ctx, post.ID, firstReply.Seq, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Comment{second, nestedReply}, comments)
+
+	comments, err = database.GetCommentsSince(ctx, post.ID, 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{first.ID, firstReply.ID}, commentIDs(comments))
+
+	comments, err = database.GetCommentsSince(ctx, post.ID, nestedReply.Seq, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+
+	comments, err = database.GetCommentsSince(ctx, uuid.New().String(), 0, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+
+	comments, err = database.GetCommentsSince(ctx, "not-a-uuid", 0, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+}
+
+func testDeepNesting(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+
+	const depth = 50
+	thread := []*model.Comment{createComment(t, database, post, nil)}
+	for i := 1; i < depth; i++ {
+		thread = append(thread, createComment(t, database, post, thread[i-1]))
+	}
+
+	deepest, err := database.GetCommentById(ctx, thread[depth-1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, thread[depth-1], deepest)
+
+	replies, err := database.GetComments(ctx, post.ID, &thread[depth-2].ID, 10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Comment{thread[depth-1]}, replies)
+
+	comments, err := database.GetComments(ctx, post.ID, nil, 10, nil)
+	assert.NoError(t, err)
+	require.Len(t, comments, 1)
+
+	comment := comments[0]
+	for level := 1; level <= db.DefaultMaxDepth; level++ {
+		require.Len(t, comment.Children, 1, "level %d", level)
+		comment = comment.Children[0]
+		assert.Equal(t, thread[level].ID, comment.ID)
+	}
+	assert.Empty(t, comment.Children)
+}
+
+func testUpdateComment(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+	comment := createComment(t, database, post, nil)
+	reply := createComment(t, database, post, comment)
+
+	reply.Body = "Updated Comment"
+	reply.UpdatedAt = reply.CreatedAt.Add(time.Hour)
+	assert.NoError(t, database.UpdateComment(ctx, reply))
+
+	fetchedComment, err := database.GetCommentById(ctx, reply.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, reply, fetchedComment)
+
+	assert.ErrorIs(t, database.UpdateComment(ctx, newComment(post, nil)), db.ErrNotFound)
+}
+
+func testDeleteComment(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	author := &model.User{ID: uuid.New().String(), Name: "Test User"}
+	require.NoError(t, database.SaveUser(ctx, author))
+
+	post := createPost(t, database)
+	comment := newComment(post, nil)
+	comment.AuthorID = &author.ID
+	require.NoError(t, database.CreateComment(ctx, post, comment))
+	reply := createComment(t, database, post, comment)
+
+	assert.NoError(t, database.DeleteComment(ctx, comment.ID))
+
+	tombstone, err := database.GetCommentById(ctx, comment.ID)
+	assert.NoError(t, err)
+	assert.True(t, tombstone.Deleted)
+	assert.Equal(t, db.DeletedCommentBody, tombstone.Body)
+	assert.Nil(t, tombstone.AuthorID)
+	assert.Equal(t, []string{reply.ID}, commentIDs(tombstone.Children))
+
+	comments, err := database.GetComments(ctx, post.ID, nil, 10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{comment.ID}, commentIDs(comments))
+
+	assert.NoError(t, database.DeleteComment(ctx, reply.ID))
+	_, err = database.GetCommentById(ctx, reply.ID)
+	assert.Error(t, err)
+
+	replies, err := database.GetComments(ctx, post.ID, &comment.ID, 10, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, replies)
+
+	assert.Error(t, database.DeleteComment(ctx, reply.ID))
+}
+
+func testDeleteCommentRemovesEmptyTombstones(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+	root := createComment(t, database, post, nil)
+	first := createComment(t, database, post, root)
+	second := createComment(t, database, post, first)
+	sibling := createComment(t, database, post, second)
+	leaf := createComment(t, database, post, second)
+
+	// first and second become tombstones, they still have replies.
+	require.NoError(t, database.DeleteComment(ctx, first.ID))
+	require.NoError(t, database.DeleteComment(ctx, second.ID))
+
+	// second keeps the other reply.
+	require.NoError(t, database.DeleteComment(ctx, leaf.ID))
+	tombstone, err := database.GetCommentById(ctx, second.ID)
+	require.NoError(t, err)
+	assert.True(t, tombstone.Deleted)
+
+	// The last reply takes the tombstones above it along, but not the live root.
+	require.NoError(t, database.DeleteComment(ctx, sibling.ID))
+	for _, id := range []string{second.ID, first.ID} {
+		_, err = database.GetCommentById(ctx, id)
+		assert.ErrorIs(t, err, db.ErrNotFound)
+	}
+	fetchedRoot, err := database.GetCommentById(ctx, root.ID)
+	require.NoError(t, err)
+	assert.False(t, fetchedRoot.Deleted)
+	assert.Empty(t, fetchedRoot.Children)
+
+	fetchedPost, err := database.GetPostById(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, fetchedPost.CommentCount)
+}
+
+// The last two replies of a tombstone deleted at once must not both leave it
+// to the other one.
+func testConcurrentDeletesRemoveEmptyTombstones(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+	for i := 0; i < 10; i++ {
+		parent := createComment(t, database, post, nil)
+		replies := []*model.Comment{
+			createComment(t, database, post, parent),
+			createComment(t, database, post, parent),
+		}
+		require.NoError(t, database.DeleteComment(ctx, parent.ID))
+
+		var wg sync.WaitGroup
+		errs := make(chan error, len(replies))
+		for _, reply := range replies {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				errs <- database.DeleteComment(ctx, id)
+			}(reply.ID)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		_, err := database.GetCommentById(ctx, parent.ID)
+		require.ErrorIs(t, err, db.ErrNotFound)
+	}
+}
+
+func testUsers(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	user := &model.User{ID: uuid.New().String(), Name: "Test User"}
+
+	assert.NoError(t, database.SaveUser(ctx, user))
+	fetchedUser, err := database.GetUserById(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, user, fetchedUser)
+
+	user.Name = "Renamed User"
+	assert.NoError(t, database.SaveUser(ctx, user))
+	fetchedUser, err = database.GetUserById(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, user, fetchedUser)
+
+	_, err = database.GetUserById(ctx, uuid.New().String())
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	other := &model.User{ID: uuid.New().String(), Name: "Other User"}
+	require.NoError(t, database.SaveUser(ctx, other))
+	users, err := database.GetUsersByIds(ctx, []string{user.ID, uuid.New().String(), other.ID})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*model.User{user, other}, users)
+	users, err = database.GetUsersByIds(ctx, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+
+	post := newPost(true)
+	post.AuthorID = &user.ID
+	require.NoError(t, database.CreatePost(ctx, post))
+	fetchedPost, err := database.GetPostById(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, post, fetchedPost)
+}
+
+func newWebhook(postID *string) *model.Webhook {
+	return &model.Webhook{
+		ID:     uuid.New().String(),
+		URL:    "https://example.com/webhook",
+		Events: []model.WebhookEvent{model.WebhookEventPostAdded, model.WebhookEventCommentAdded},
+		PostID: postID,
+		Secret: "test_secret",
+	}
+}
+
+func newDeadLetter(webhook *model.Webhook, failedAt time.Time) *model.WebhookDelivery {
+	return &model.WebhookDelivery{
+		ID:        uuid.New().String(),
+		WebhookID: webhook.ID,
+		Event:     model.WebhookEventCommentAdded,
+		Payload:   `{"event":"COMMENT_ADDED"}`,
+		Attempts:  5,
+		LastError: "unexpected status: 500 Internal Server Error",
+		FailedAt:  failedAt,
+	}
+}
+
+func testWebhooks(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+
+	webhooks, err := database.GetWebhooks(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, webhooks)
+
+	webhook := newWebhook(nil)
+	postWebhook := newWebhook(&post.ID)
+	postWebhook.Events = []model.WebhookEvent{model.WebhookEventCommentAdded}
+	require.NoError(t, database.CreateWebhook(ctx, webhook))
+	require.NoError(t, database.CreateWebhook(ctx, postWebhook))
+
+	webhooks, err = database.GetWebhooks(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*model.Webhook{webhook, postWebhook}, webhooks)
+
+	assert.NoError(t, database.DeleteWebhook(ctx, webhook.ID))
+	webhooks, err = database.GetWebhooks(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Webhook{postWebhook}, webhooks)
+
+	assert.ErrorIs(t, database.DeleteWebhook(ctx, webhook.ID), db.ErrNotFound)
+	assert.ErrorIs(t, database.DeleteWebhook(ctx, "not-a-uuid"), db.ErrNotFound)
+}
+
+func testDeadLetters(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	webhook := newWebhook(nil)
+	otherWebhook := newWebhook(nil)
+	require.NoError(t, database.CreateWebhook(ctx, webhook))
+	require.NoError(t, database.CreateWebhook(ctx, otherWebhook))
+
+	// Microseconds survive every backend.
+	failedAt := time.Now().UTC().Truncate(time.Microsecond)
+	first := newDeadLetter(webhook, failedAt)
+	other := newDeadLetter(otherWebhook, failedAt.Add(time.Second))
+	second := newDeadLetter(webhook, failedAt.Add(2*time.Second))
+	for _, delivery := range []*model.WebhookDelivery{first, other, second} {
+		require.NoError(t, database.CreateDeadLetter(ctx, delivery))
+	}
+
+	deliveries, err := database.GetDeadLetters(ctx, nil, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{second.ID, other.ID, first.ID}, deliveryIDs(deliveries))
+	assert.Equal(t, second.Payload, deliveries[0].Payload)
+	assert.Equal(t, second.Attempts, deliveries[0].Attempts)
+	assert.Equal(t, second.LastError, deliveries[0].LastError)
+	assert.Equal(t, second.Event, deliveries[0].Event)
+	assert.True(t, second.FailedAt.Equal(deliveries[0].FailedAt))
+
+	deliveries, err = database.GetDeadLetters(ctx, &webhook.ID, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{second.ID, first.ID}, deliveryIDs(deliveries))
+
+	deliveries, err = database.GetDeadLetters(ctx, nil, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{second.ID}, deliveryIDs(deliveries))
+
+	invalidID := "not-a-uuid"
+	deliveries, err = database.GetDeadLetters(ctx, &invalidID, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, deliveries)
+
+	// Dead letters go away with their webhook.
+	require.NoError(t, database.DeleteWebhook(ctx, webhook.ID))
+	deliveries, err = database.GetDeadLetters(ctx, nil, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{other.ID}, deliveryIDs(deliveries))
+
+	assert.Error(t, database.CreateDeadLetter(ctx, newDeadLetter(webhook, failedAt)))
+}
+
+func deliveryIDs(deliveries []*model.WebhookDelivery) []string {
+	ids := make([]string, len(deliveries))
+	for i, delivery := range deliveries {
+		ids[i] = delivery.ID
+	}
+	return ids
+}
+
+func testReturnedValuesAreCopies(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+	comment := createComment(t, database, post, nil)
+	createComment(t, database, post, comment)
+
+	fetchedPost, err := database.GetPostById(ctx, post.ID)
+	require.NoError(t, err)
+	fetchedPost.Title = "Changed by caller"
+
+	comments, err := database.GetComments(ctx, post.ID, nil, 10, nil)
+	require.NoError(t, err)
+	comments[0].Body = "Changed by caller"
+	comments[0].Children = nil
+
+	fetchedPost, err = database.GetPostById(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, post.Title, fetchedPost.Title)
+
+	fetchedComment, err := database.GetCommentById(ctx, comment.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, comment.Body, fetchedComment.Body)
+	assert.Len(t, fetchedComment.Children, 1)
+}
+
+func testConcurrentComments(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+	parent := createComment(t, database, post, nil)
+
+	const workers, perWorker = 8, 10
+	var wg sync.WaitGroup
+	// Every iteration may fail twice, a full channel would block the workers.
+	errs := make(chan error, 2*workers*perWorker)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				comment := newComment(post, parent)
+				comment.Body = fmt.Sprintf("Comment %d-%d", w, i)
+				if err := database.CreateComment(ctx, post, comment); err != nil {
+					errs <- err
+				}
+				if _, err := database.GetComments(ctx, post.ID, &parent.ID, 5, nil); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	replies, err := database.GetComments(ctx, post.ID, &parent.ID, workers*perWorker+1, nil)
+	assert.NoError(t, err)
+	assert.Len(t, replies, workers*perWorker)
+	for i := 1; i < len(replies); i++ {
+		assert.Less(t, replies[i-1].Seq, replies[i].Seq)
+	}
+}
+
+// testCommentsVisibleInSeqOrder pages through comments while they are being
+// created. A comment that shows up with a Seq below the cursor of a page
+// already read would never be returned, the cursors must not skip any.
+func testCommentsVisibleInSeqOrder(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	post := createPost(t, database)
+
+	const workers, perWorker = 8, 10
+	var wg sync.WaitGroup
+	created := make(chan string, workers*perWorker)
+	errs := make(chan error, workers*perWorker)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				comment := newComment(post, nil)
+				if err := database.CreateComment(ctx, post, comment); err != nil {
+					errs <- err
+					continue
+				}
+				created <- comment.ID
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	since, paged := make(map[string]bool), make(map[string]bool)
+	var afterSeq int64
+	var after *int64
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+
+		comments, err := database.GetCommentsSince(ctx, post.ID, afterSeq, workers*perWorker)
+		require.NoError(t, err)
+		for _, comment := range comments {
+			assert.False(t, since[comment.ID], "comment %s returned twice", comment.ID)
+			since[comment.ID] = true
+			afterSeq = comment.Seq
+		}
+
+		comments, err = database.GetComments(ctx, post.ID, nil, workers*perWorker, after)
+		require.NoError(t, err)
+		for _, comment := range comments {
+			assert.False(t, paged[comment.ID], "comment %s returned twice", comment.ID)
+			paged[comment.ID] = true
+			after = &comment.Seq
+		}
+	}
+
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	close(created)
+	for id := range created {
+		assert.True(t, since[id], "comment %s skipped by GetCommentsSince", id)
+		assert.True(t, paged[id], "comment %s skipped by GetComments", id)
+	}
+}
+
+func testPing(t *testing.T, database db.Database) {
+	assert.NoError(t, database.Ping(context.Background()))
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound matches the errors of all backends about posts, comments,
+// users and webhooks that don't exist, check it with errors.Is.
+var ErrNotFound = errors.New("not found")
+
+// ErrParentPostMismatch is returned for a reply to a comment of another post.
+var ErrParentPostMismatch = errors.New("parent comment belongs to another post")
+
+// NotFoundError names what was not found, Kind is plural, like "posts".
+type NotFoundError struct {
+	Kind string
+	ID   string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("no %s with this id: %s", e.Kind, e.ID)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+func notFound(kind string, id string) error {
+	return &NotFoundError{Kind: kind, ID: id}
+}
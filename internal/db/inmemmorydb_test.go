@@ -2,8 +2,10 @@ package db_test
 
 import (
 	"context"
+	"fmt"
 	"postsandcomments/internal/graph/model"
 	"postsandcomments/internal/db"
+	"postsandcomments/internal/db/dbtest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -17,6 +19,12 @@ func TestNewInMemoryDB(t *testing.T) {
 	assert.Equal(t, make(map[string]*model.Comment), db.Comments)
 }
 
+func TestInMemoryConformance(t *testing.T) {
+	dbtest.RunConformance(t, func() db.Database {
+		return db.NewInMemoryDB()
+	})
+}
+
 func TestCreatePostInMemory(t *testing.T) {
 	db := db.NewInMemoryDB()
 
@@ -31,49 +39,7 @@ func TestCreatePostInMemory(t *testing.T) {
 	assert.Contains(t, db.Posts, post.ID)
 }
 
-func TestGetPostsInMemory(t *testing.T) {
-	db := db.NewInMemoryDB()
-
-	post1 := &model.Post{
-		ID:            "test_post_1",
-		Title:         "First Post",
-		Body:          "Test body",
-		AllowComments: true,
-	}
-	post2 := &model.Post{
-		ID:            "test_post_2",
-		Title:         "Second Post",
-		Body:          "Test body",
-		AllowComments: false,
-	}
-
-	db.CreatePost(context.Background(), post1)
-	db.CreatePost(context.Background(), post2)
-
-	posts, _ := db.GetPosts(context.Background())
-	assert.Len(t, posts, 2)
-	assert.Contains(t, posts, post1)
-	assert.Contains(t, posts, post2)
-}
-
-func TestGetPostByIdInMemory(t *testing.T) {
-	db := db.NewInMemoryDB()
-
-	post := &model.Post{
-		ID:            "test_post_id",
-		Title:         "Test Post",
-		Body:          "Test body",
-		AllowComments: true,
-	}
-	
-	err := db.CreatePost(context.Background(), post)
-	assert.NoError(t, err)
-
-	retrievedPost, err := db.GetPostById(context.Background(), post.ID, nil, nil)
-	assert.NoError(t, err)
-	assert.Equal(t, post, retrievedPost)
-}
-func TestGetCommentByIdInMemory(t *testing.T) {
+func TestCreateCommentInMemory(t *testing.T) {
 	db := db.NewInMemoryDB()
 
 	post := &model.Post{
@@ -88,18 +54,50 @@ func TestGetCommentByIdInMemory(t *testing.T) {
 		Body:     "Test Comment 1",
 		ParentID: nil,
 	}
-	err := db.CreatePost(context.Background(), post)
+	db.CreatePost(context.Background(), post)
+
+	err := db.CreateComment(context.Background(), post, comment)
 	assert.NoError(t, err)
-	err = db.CreateComment(context.Background(), post, comment)
+	assert.Contains(t, db.PostComments[post.ID], comment.ID)
+	assert.Contains(t, db.Comments, comment.ID)
+
+	childrenComment := &model.Comment{
+		ID:       "child_comment_id",
+		PostID:   post.ID,
+		Body:     "Test Comment child",
+		ParentID: &comment.ID,
+	}
+	err = db.CreateComment(context.Background(), post, childrenComment)
 	assert.NoError(t, err)
+	assert.Contains(t, db.Replies[comment.ID], childrenComment.ID)
+	assert.Contains(t, db.Comments, childrenComment.ID)
 
-	retrievedComment, err := db.GetCommentById(context.Background(), comment.ID)
+	grandchildComment := &model.Comment{
+		ID:       "grandchild_comment_id",
+		PostID:   post.ID,
+		Body:     "Test Comment grandchild",
+		ParentID: &childrenComment.ID,
+	}
+	err = db.CreateComment(context.Background(), post, grandchildComment)
+	assert.NoError(t, err)
+
+	retrievedComment, err := db.GetCommentById(context.Background(), grandchildComment.ID)
 	assert.NoError(t, err)
-	assert.Equal(t, comment, retrievedComment)
+	assert.Equal(t, grandchildComment, retrievedComment)
+
+	missingParentID := "missing_comment_id"
+	err = db.CreateComment(context.Background(), post, &model.Comment{
+		ID:       "orphan_comment_id",
+		PostID:   post.ID,
+		Body:     "Test Comment orphan",
+		ParentID: &missingParentID,
+	})
+	assert.Error(t, err)
 }
 
-func TestGetPostByIdWithLimitAndOffsetInMemory(t *testing.T) {
+func TestGetCommentsWithMaxDepthInMemory(t *testing.T) {
 	db := db.NewInMemoryDB()
+	db.MaxDepth = 2
 
 	post := &model.Post{
 		ID:            "test_post_id",
@@ -107,74 +105,80 @@ func TestGetPostByIdWithLimitAndOffsetInMemory(t *testing.T) {
 		Body:          "Test body",
 		AllowComments: true,
 	}
-	err := db.CreatePost(context.Background(), post)
-	assert.NoError(t, err)
+	db.CreatePost(context.Background(), post)
 
-	comments := []*model.Comment{
-		{
-			ID:       "comment_post_1",
-			PostID:   post.ID,
-			Body:     "Test Comment 1",
-			ParentID: nil,
-		},
-		{
-			ID:       "comment_post_2",
+	var parentID *string
+	thread := make([]*model.Comment, 0)
+	for i := 0; i < 4; i++ {
+		comment := &model.Comment{
+			ID:       fmt.Sprintf("comment_level_%d", i),
 			PostID:   post.ID,
-			Body:     "Test Comment 2",
-			ParentID: nil,
-		},
-	}
-	comments = append(comments, &model.Comment{
-		ID:       "comment_post_1-1",
-		PostID:   post.ID,
-		Body:     "Test Comment 1-1",
-		ParentID: &comments[0].ID,
-	})
-
-	for _, comment := range comments {
-		err = db.CreateComment(context.Background(), post, comment)
+			Body:     "Test Comment",
+			ParentID: parentID,
+		}
+		err := db.CreateComment(context.Background(), post, comment)
 		assert.NoError(t, err)
+		thread = append(thread, comment)
+		parentID = &comment.ID
 	}
 
-	limit, offset := 1, 1
-	fetchedPost, err := db.GetPostById(context.Background(), post.ID, &limit, &offset)
-
+	comments, err := db.GetComments(context.Background(), post.ID, nil, 10, nil)
 	assert.NoError(t, err)
-	assert.Equal(t, comments[1], fetchedPost.Comments[0])
-	limit, offset = 1, 2
-	fetchedPost, err = db.GetPostById(context.Background(), post.ID, &limit, &offset)
+	assert.Len(t, comments, 1)
+	assert.Len(t, comments[0].Children, 1)
+	assert.Equal(t, thread[1].ID, comments[0].Children[0].ID)
+	assert.Len(t, comments[0].Children[0].Children, 1)
+	assert.Equal(t, thread[2].ID, comments[0].Children[0].Children[0].ID)
+	assert.Empty(t, comments[0].Children[0].Children[0].Children)
+
+	comment, err := db.GetCommentById(context.Background(), thread[1].ID)
 	assert.NoError(t, err)
-	assert.Equal(t, comments[2], fetchedPost.Comments[0])
+	assert.Len(t, comment.Children, 1)
+	assert.Len(t, comment.Children[0].Children, 1)
+	assert.Equal(t, thread[3].ID, comment.Children[0].Children[0].ID)
 }
 
-func TestCreateCommentInMemory(t *testing.T) {
+func TestCopyOnReadInMemory(t *testing.T) {
 	db := db.NewInMemoryDB()
 
+	authorID := "test_user_id"
 	post := &model.Post{
 		ID:            "test_post_id",
 		Title:         "Test Post",
 		Body:          "Test body",
 		AllowComments: true,
+		AuthorID:      &authorID,
 	}
 	comment := &model.Comment{
-		ID:       "comment_post_1",
-		PostID:   post.ID,
-		Body:     "Test Comment 1",
-		ParentID: nil,
+		ID:     "comment_post_1",
+		PostID: post.ID,
+		Body:   "Test Comment 1",
 	}
 	db.CreatePost(context.Background(), post)
+	db.CreateComment(context.Background(), post, comment)
 
-	err := db.CreateComment(context.Background(), post, comment)
+	post.Title = "Changed by caller"
+	comment.Body = "Changed by caller"
+
+	retrievedPost, err := db.GetPostById(context.Background(), post.ID)
 	assert.NoError(t, err)
-	assert.Contains(t, post.Comments, comment)
-	assert.Contains(t, db.Comments, comment.ID)
+	assert.Equal(t, "Test Post", retrievedPost.Title)
+	retrievedPost.Body = "Changed by caller"
+	*retrievedPost.AuthorID = "another_user_id"
 
-	childrenComment := &model.Comment{
-		ID:       "child_comment_id",
-		PostID:   post.ID,
-		Body:     "Test Comment child",
-		ParentID: &comment.ID,
-	}
-	db.CreateComment(context.Background(), post, childrenComment)
-	assert.Contains(t, comment.Children, childrenComment)
+	retrievedPost, err = db.GetPostById(context.Background(), post.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Test body", retrievedPost.Body)
+	assert.Equal(t, "test_user_id", *retrievedPost.AuthorID)
+
+	comments, err := db.GetComments(context.Background(), post.ID, nil, 10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Test Comment 1", comments[0].Body)
+	comments[0].Body = "Changed by caller"
+	comments[0].Children = append(comments[0].Children, &model.Comment{ID: "fake_comment_id"})
+
+	retrievedComment, err := db.GetCommentById(context.Background(), comment.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Test Comment 1", retrievedComment.Body)
+	assert.Empty(t, retrievedComment.Children)
 }
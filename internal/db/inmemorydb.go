@@ -4,20 +4,39 @@ import (
 	"context"
 	"fmt"
 	"postsandcomments/internal/graph/model"
+	"sort"
 	"sync"
 )
 
+// InMemoryDB keeps posts and comments in flat indexes. Stored values are never
+// handed out: writes store copies and reads return copies, so callers can't
+// change the database through the pointers they hold.
+//
+// NewInMemoryDB keeps everything in memory only, OpenInMemoryDB also journals
+// every change to disk and restores the state on startup.
 type InMemoryDB struct {
-	Posts    map[string]*model.Post
-	Comments map[string]*model.Comment
-	Mutex    sync.RWMutex
+	Posts        map[string]*model.Post
+	Comments     map[string]*model.Comment
+	PostComments map[string][]string
+	Replies      map[string][]string
+	Users        map[string]*model.User
+	Webhooks     map[string]*model.Webhook
+	DeadLetters  []*model.WebhookDelivery
+	Mutex        sync.RWMutex
+	MaxDepth     int
+	lastSeq      int64
+	journal      *journal
 }
 
 func NewInMemoryDB() *InMemoryDB {
 	return &InMemoryDB{
-		Posts:    make(map[string]*model.Post),
-		Comments: make(map[string]*model.Comment),
-		Mutex:    sync.RWMutex{},
+		Posts:        make(map[string]*model.Post),
+		Comments:     make(map[string]*model.Comment),
+		PostComments: make(map[string][]string),
+		Replies:      make(map[string][]string),
+		Users:        make(map[string]*model.User),
+		Webhooks:     make(map[string]*model.Webhook),
+		Mutex:        sync.RWMutex{},
 	}
 }
 
@@ -25,100 +44,449 @@ func (db *InMemoryDB) CreatePost(ctx context.Context, post *model.Post) error {
 	db.Mutex.Lock()
 	defer db.Mutex.Unlock()
 
-	db.Posts[post.ID] = post
+	if _, exists := db.Posts[post.ID]; exists {
+		return fmt.Errorf("post with this id already exists: %s", post.ID)
+	}
+	stored := toStoredPost(post)
+	if err := db.record(journalEntry{Op: opCreatePost, Post: &stored}); err != nil {
+		return err
+	}
+
+	post.CommentCount = 0
+	post.LastActivityAt = post.CreatedAt
+	db.Posts[post.ID] = copyPost(post)
 
 	return nil
 }
 
-func (db *InMemoryDB) GetPosts(ctx context.Context) ([]*model.Post, error) {
+// GetPosts sorts only the posts that pass the filters and come after the cursor.
+func (db *InMemoryDB) GetPosts(ctx context.Context, query PostQuery) ([]*model.Post, error) {
 	db.Mutex.RLock()
 	defer db.Mutex.RUnlock()
 
-	posts := make([]*model.Post, 0, len(db.Posts))
+	matched := make([]*model.Post, 0)
 	for _, post := range db.Posts {
-		posts = append(posts, post)
+		if !query.matches(post) || query.After != nil && !postBefore(query.OrderBy, query.After, post) {
+			continue
+		}
+		matched = append(matched, post)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return postBefore(query.OrderBy, matched[i], matched[j])
+	})
+	if len(matched) > query.First {
+		matched = matched[:query.First]
+	}
+
+	posts := make([]*model.Post, 0, len(matched))
+	for _, post := range matched {
+		posts = append(posts, copyPost(post))
 	}
 
 	return posts, nil
 }
 
-func (db *InMemoryDB) GetCommentById(ctx context.Context, id string) (*model.Comment, error) {
+func (db *InMemoryDB) GetPostById(ctx context.Context, id string) (*model.Post, error) {
 	db.Mutex.RLock()
 	defer db.Mutex.RUnlock()
 
-	comment, exists := db.Comments[id]
+	post, exists := db.Posts[id]
 	if !exists {
-		return nil, fmt.Errorf("no comments with this id: %s", id)
+		return nil, notFound("posts", id)
 	}
 
-	return comment, nil
+	return copyPost(post), nil
 }
 
-func (db *InMemoryDB) GetPostById(ctx context.Context, id string, limit *int, offset *int) (*model.Post, error) {
-	db.Mutex.RLock()
-	defer db.Mutex.RUnlock()
+func (db *InMemoryDB) UpdatePost(ctx context.Context, post *model.Post) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
 
-	post, exists := db.Posts[id]
+	storedPost, exists := db.Posts[post.ID]
+	if !exists {
+		return notFound("posts", post.ID)
+	}
+	stored := toStoredPost(post)
+	if err := db.record(journalEntry{Op: opUpdatePost, Post: &stored}); err != nil {
+		return err
+	}
+
+	storedPost.Title = post.Title
+	storedPost.Body = post.Body
+	storedPost.AllowComments = post.AllowComments
+	storedPost.UpdatedAt = post.UpdatedAt
+
+	return nil
+}
+
+func (db *InMemoryDB) DeletePost(ctx context.Context, id string) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	if _, exists := db.Posts[id]; !exists {
+		return notFound("posts", id)
+	}
+	if err := db.record(journalEntry{Op: opDeletePost, ID: id}); err != nil {
+		return err
+	}
+
+	var deleteThread func(ids []string)
+	deleteThread = func(ids []string) {
+		for _, commentID := range ids {
+			deleteThread(db.Replies[commentID])
+			delete(db.Replies, commentID)
+			delete(db.Comments, commentID)
+		}
+	}
+	deleteThread(db.PostComments[id])
+	delete(db.PostComments, id)
+	delete(db.Posts, id)
+
+	return nil
+}
+
+func (db *InMemoryDB) CreateComment(ctx context.Context, post *model.Post, comment *model.Comment) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	storedPost, exists := db.Posts[post.ID]
 	if !exists {
-		return nil, fmt.Errorf("no posts with this id: %s", id)
+		return notFound("posts", post.ID)
+	}
+	if _, exists := db.Comments[comment.ID]; exists {
+		return fmt.Errorf("comment with this id already exists: %s", comment.ID)
+	}
+
+	if comment.ParentID != nil {
+		parent, exists := db.Comments[*comment.ParentID]
+		if !exists {
+			return notFound("comments", *comment.ParentID)
+		}
+		if parent.PostID != post.ID {
+			return fmt.Errorf("%w: %s", ErrParentPostMismatch, parent.ID)
+		}
 	}
 
-	if limit != nil && offset != nil {
-		return &model.Post{
-			ID:            post.ID,
-			Title:         post.Title,
-			Body:          post.Body,
-			AllowComments: post.AllowComments,
-			Comments:      GetComments(post.Comments, *limit, *offset),
-		}, nil
+	stored := copyComment(comment)
+	stored.PostID = post.ID
+	stored.Seq = db.lastSeq + 1
+	entry := toStoredComment(stored)
+	if err := db.record(journalEntry{Op: opCreateComment, Comment: &entry}); err != nil {
+		return err
 	}
+	db.lastSeq = stored.Seq
+	comment.Seq = stored.Seq
 
-	return post, nil
+	db.Comments[stored.ID] = stored
+	if stored.ParentID != nil {
+		db.Replies[*stored.ParentID] = append(db.Replies[*stored.ParentID], stored.ID)
+	} else {
+		db.PostComments[post.ID] = append(db.PostComments[post.ID], stored.ID)
+	}
+	storedPost.CommentCount++
+	if stored.CreatedAt.After(storedPost.LastActivityAt) {
+		storedPost.LastActivityAt = stored.CreatedAt
+	}
+
+	return nil
 }
-func GetComments(comments []*model.Comment, limit int, offset int) []*model.Comment {
-	result := make([]*model.Comment, 0)
-	startIndex, endIndex := offset, limit+offset
-	var processComents func(comments []*model.Comment, startIndex, endIndex int)
-	processComents = func(comments []*model.Comment, startIndex, endIndex int) {
-		if len(result) == endIndex {
-			return
+
+func (db *InMemoryDB) GetCommentById(ctx context.Context, id string) (*model.Comment, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	if _, exists := db.Comments[id]; !exists {
+		return nil, notFound("comments", id)
+	}
+
+	return db.commentTree(id, maxDepth(db.MaxDepth)), nil
+}
+
+func (db *InMemoryDB) GetComments(ctx context.Context, postID string, parentID *string, first int, after *int64) ([]*model.Comment, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	ids := db.PostComments[postID]
+	if parentID != nil {
+		ids = nil
+		if parent, exists := db.Comments[*parentID]; exists && parent.PostID == postID {
+			ids = db.Replies[*parentID]
 		}
-		for _, comment := range comments {
+	}
+
+	comments := make([]*model.Comment, 0, first)
+	for _, id := range ids {
+		if len(comments) == first {
+			break
+		}
+		if after != nil && db.Comments[id].Seq <= *after {
+			continue
+		}
+		comments = append(comments, db.commentTree(id, maxDepth(db.MaxDepth)))
+	}
+
+	return comments, nil
+}
+
+func (db *InMemoryDB) GetCommentsSince(ctx context.Context, postID string, afterSeq int64, limit int) ([]*model.Comment, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
 
-			if len(result) < endIndex {
-				result = append(result, comment)
+	comments := make([]*model.Comment, 0)
+	var collect func(ids []string)
+	collect = func(ids []string) {
+		for _, id := range ids {
+			if comment := db.Comments[id]; comment.Seq > afterSeq {
+				comments = append(comments, copyComment(comment))
 			}
+			collect(db.Replies[id])
 		}
+	}
+	collect(db.PostComments[postID])
+
+	sort.Slice(comments, func(i, j int) bool {
+		return comments[i].Seq < comments[j].Seq
+	})
+	if len(comments) > limit {
+		comments = comments[:limit]
+	}
+
+	return comments, nil
+}
+
+func (db *InMemoryDB) UpdateComment(ctx context.Context, comment *model.Comment) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	storedComment, exists := db.Comments[comment.ID]
+	if !exists {
+		return notFound("comments", comment.ID)
+	}
+	entry := toStoredComment(comment)
+	if err := db.record(journalEntry{Op: opUpdateComment, Comment: &entry}); err != nil {
+		return err
+	}
+
+	storedComment.Body = comment.Body
+	storedComment.UpdatedAt = comment.UpdatedAt
+
+	return nil
+}
+
+func (db *InMemoryDB) DeleteComment(ctx context.Context, id string) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
 
-		for _, comment := range comments {
-			processComents(comment.Children, startIndex, endIndex)
+	comment, exists := db.Comments[id]
+	if !exists {
+		return notFound("comments", id)
+	}
+	if err := db.record(journalEntry{Op: opDeleteComment, ID: id}); err != nil {
+		return err
+	}
+
+	// A tombstone is not counted already.
+	if post, exists := db.Posts[comment.PostID]; exists && !comment.Deleted {
+		post.CommentCount--
+	}
+
+	if len(db.Replies[id]) > 0 {
+		comment.Body = DeletedCommentBody
+		comment.Deleted = true
+		comment.AuthorID = nil
+		return nil
+	}
+
+	db.removeComment(comment)
+	// Tombstones are kept only to hold replies, so a tombstone left without
+	// replies is removed too, and so on up the thread.
+	for comment.ParentID != nil {
+		parent, exists := db.Comments[*comment.ParentID]
+		if !exists || !parent.Deleted || len(db.Replies[parent.ID]) > 0 {
+			break
 		}
+		db.removeComment(parent)
+		comment = parent
 	}
 
-	processComents(comments, startIndex, endIndex)
-	if endIndex > len(result) {
-		result = result[startIndex:]
+	return nil
+}
+
+func (db *InMemoryDB) removeComment(comment *model.Comment) {
+	if comment.ParentID != nil {
+		db.Replies[*comment.ParentID] = removeID(db.Replies[*comment.ParentID], comment.ID)
 	} else {
-		result = result[startIndex:endIndex]
+		db.PostComments[comment.PostID] = removeID(db.PostComments[comment.PostID], comment.ID)
 	}
+	delete(db.Replies, comment.ID)
+	delete(db.Comments, comment.ID)
+}
+
+func (db *InMemoryDB) SaveUser(ctx context.Context, user *model.User) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
 
-	return result
+	if err := db.record(journalEntry{Op: opSaveUser, User: &model.User{ID: user.ID, Name: user.Name}}); err != nil {
+		return err
+	}
+	db.Users[user.ID] = &model.User{ID: user.ID, Name: user.Name}
+
+	return nil
 }
 
-func (db *InMemoryDB) CreateComment(ctx context.Context, post *model.Post, comment *model.Comment) error {
+func (db *InMemoryDB) GetUserById(ctx context.Context, id string) (*model.User, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	user, exists := db.Users[id]
+	if !exists {
+		return nil, notFound("users", id)
+	}
+
+	return &model.User{ID: user.ID, Name: user.Name}, nil
+}
+
+func (db *InMemoryDB) GetUsersByIds(ctx context.Context, ids []string) ([]*model.User, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	users := make([]*model.User, 0, len(ids))
+	for _, id := range ids {
+		if user, exists := db.Users[id]; exists {
+			users = append(users, &model.User{ID: user.ID, Name: user.Name})
+		}
+	}
+
+	return users, nil
+}
+
+func (db *InMemoryDB) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
 	db.Mutex.Lock()
 	defer db.Mutex.Unlock()
 
-	if comment.ParentID != nil {
-		ParentComment, exists := db.Comments[*comment.ParentID]
-		if !exists {
-			return fmt.Errorf("no comments with this id: %s", *comment.ParentID)
+	if err := db.record(journalEntry{Op: opCreateWebhook, Webhook: webhook}); err != nil {
+		return err
+	}
+	db.Webhooks[webhook.ID] = copyWebhook(webhook)
+
+	return nil
+}
+
+func (db *InMemoryDB) GetWebhooks(ctx context.Context) ([]*model.Webhook, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	webhooks := make([]*model.Webhook, 0, len(db.Webhooks))
+	for _, webhook := range db.Webhooks {
+		webhooks = append(webhooks, copyWebhook(webhook))
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+
+	return webhooks, nil
+}
+
+func (db *InMemoryDB) DeleteWebhook(ctx context.Context, id string) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	if _, exists := db.Webhooks[id]; !exists {
+		return notFound("webhooks", id)
+	}
+	if err := db.record(journalEntry{Op: opDeleteWebhook, ID: id}); err != nil {
+		return err
+	}
+
+	delete(db.Webhooks, id)
+	deadLetters := db.DeadLetters[:0]
+	for _, delivery := range db.DeadLetters {
+		if delivery.WebhookID != id {
+			deadLetters = append(deadLetters, delivery)
 		}
+	}
+	db.DeadLetters = deadLetters
 
-		ParentComment.Children = append(ParentComment.Children, comment)
-	} else {
-		db.Comments[comment.ID] = comment
-		post.Comments = append(post.Comments, comment)
+	return nil
+}
+
+func (db *InMemoryDB) CreateDeadLetter(ctx context.Context, delivery *model.WebhookDelivery) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	if _, exists := db.Webhooks[delivery.WebhookID]; !exists {
+		return notFound("webhooks", delivery.WebhookID)
+	}
+	if err := db.record(journalEntry{Op: opCreateDeadLetter, Delivery: delivery}); err != nil {
+		return err
 	}
+	copied := *delivery
+	db.DeadLetters = append(db.DeadLetters, &copied)
+
 	return nil
 }
+
+func (db *InMemoryDB) GetDeadLetters(ctx context.Context, webhookID *string, limit int) ([]*model.WebhookDelivery, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	deliveries := make([]*model.WebhookDelivery, 0)
+	for i := len(db.DeadLetters) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		delivery := db.DeadLetters[i]
+		if webhookID != nil && delivery.WebhookID != *webhookID {
+			continue
+		}
+		copied := *delivery
+		deliveries = append(deliveries, &copied)
+	}
+
+	return deliveries, nil
+}
+
+// commentTree returns a copy of the stored comment with replies filled depth levels deep.
+func (db *InMemoryDB) commentTree(id string, depth int) *model.Comment {
+	comment := copyComment(db.Comments[id])
+	if depth > 0 {
+		for _, replyID := range db.Replies[id] {
+			comment.Children = append(comment.Children, db.commentTree(replyID, depth-1))
+		}
+	}
+	return comment
+}
+
+func copyPost(post *model.Post) *model.Post {
+	copied := *post
+	copied.AuthorID = copyString(post.AuthorID)
+	return &copied
+}
+
+// copyComment copies the comment without its Children, replies are kept in indexes.
+func copyComment(comment *model.Comment) *model.Comment {
+	copied := *comment
+	copied.ParentID = copyString(comment.ParentID)
+	copied.AuthorID = copyString(comment.AuthorID)
+	copied.Children = nil
+	return &copied
+}
+
+func copyWebhook(webhook *model.Webhook) *model.Webhook {
+	copied := *webhook
+	copied.Events = append([]model.WebhookEvent(nil), webhook.Events...)
+	copied.PostID = copyString(webhook.PostID)
+	return &copied
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	copied := *s
+	return &copied
+}
+
+func removeID(ids []string, id string) []string {
+	for i := range ids {
+		if ids[i] == id {
+			return append(ids[:i:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
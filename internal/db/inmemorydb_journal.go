@@ -0,0 +1,531 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"postsandcomments/internal/graph/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FsyncPolicy tells when journal writes are flushed to disk.
+type FsyncPolicy string
+
+const (
+	// FsyncAlways flushes every write before it is acknowledged.
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncInterval flushes in the background every FsyncInterval,
+	// a crash loses at most that much of acknowledged writes.
+	FsyncInterval FsyncPolicy = "interval"
+	// FsyncNever leaves flushing to the operating system.
+	FsyncNever FsyncPolicy = "never"
+)
+
+const (
+	journalFileName  = "journal.log"
+	snapshotFileName = "snapshot.json"
+)
+
+// DurabilityConfig configures the journal and snapshots of InMemoryDB.
+type DurabilityConfig struct {
+	// Dir keeps journal.log and snapshot.json, it is created if missing.
+	Dir           string
+	Fsync         FsyncPolicy
+	FsyncInterval time.Duration
+	// SnapshotInterval is how often the state is compacted into a snapshot
+	// and the journal is truncated. Zero disables periodic snapshots.
+	SnapshotInterval time.Duration
+}
+
+// journalEntry is one line of the journal. LSN grows with every entry and is
+// kept in snapshots, so entries already included in a snapshot are skipped on replay.
+type journalEntry struct {
+	LSN      int64                  `json:"lsn"`
+	Op       string                 `json:"op"`
+	Post     *storedPost            `json:"post,omitempty"`
+	Comment  *storedComment         `json:"comment,omitempty"`
+	User     *model.User            `json:"user,omitempty"`
+	Webhook  *model.Webhook         `json:"webhook,omitempty"`
+	Delivery *model.WebhookDelivery `json:"delivery,omitempty"`
+	ID       string                 `json:"id,omitempty"`
+}
+
+const (
+	opCreatePost    = "createPost"
+	opUpdatePost    = "updatePost"
+	opDeletePost    = "deletePost"
+	opCreateComment = "createComment"
+	opUpdateComment = "updateComment"
+	opDeleteComment = "deleteComment"
+	opSaveUser      = "saveUser"
+
+	opCreateWebhook    = "createWebhook"
+	opDeleteWebhook    = "deleteWebhook"
+	opCreateDeadLetter = "createDeadLetter"
+)
+
+// storedPost and storedComment carry the fields model hides from JSON.
+type storedPost struct {
+	ID            string    `json:"id"`
+	Title         string    `json:"title"`
+	Body          string    `json:"body"`
+	AllowComments bool      `json:"allowComments"`
+	AuthorID      *string   `json:"authorId,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	// Counters are only needed in snapshots, journal entries of comments
+	// update them on replay.
+	CommentCount   int       `json:"commentCount,omitempty"`
+	LastActivityAt time.Time `json:"lastActivityAt"`
+}
+
+type storedComment struct {
+	ID        string    `json:"id"`
+	PostID    string    `json:"postId"`
+	Body      string    `json:"body"`
+	ParentID  *string   `json:"parentId,omitempty"`
+	AuthorID  *string   `json:"authorId,omitempty"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	Seq       int64     `json:"seq"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type snapshot struct {
+	LSN         int64                   `json:"lsn"`
+	LastSeq     int64                   `json:"lastSeq"`
+	Posts       []storedPost            `json:"posts"`
+	Comments    []storedComment         `json:"comments"`
+	Users       []model.User            `json:"users"`
+	Webhooks    []model.Webhook         `json:"webhooks"`
+	DeadLetters []model.WebhookDelivery `json:"deadLetters"`
+}
+
+type journal struct {
+	config   DurabilityConfig
+	file     *os.File
+	size     int64
+	lsn      int64
+	snapshot sync.Mutex
+	done     chan struct{}
+	wg       sync.WaitGroup
+	// broken is set when a failed entry could not be cut off the journal.
+	broken error
+}
+
+// OpenInMemoryDB restores InMemoryDB from the snapshot and the journal in
+// config.Dir and keeps journaling every change made to it afterwards.
+func OpenInMemoryDB(config DurabilityConfig) (*InMemoryDB, error) {
+	switch config.Fsync {
+	case "":
+		config.Fsync = FsyncAlways
+	case FsyncAlways, FsyncNever:
+	case FsyncInterval:
+		if config.FsyncInterval <= 0 {
+			return nil, fmt.Errorf("fsync interval must be positive")
+		}
+	default:
+		return nil, fmt.Errorf("unknown fsync policy: %s", config.Fsync)
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error to create data directory: %v", err)
+	}
+
+	db := NewInMemoryDB()
+	lsn, err := db.loadSnapshot(filepath.Join(config.Dir, snapshotFileName))
+	if err != nil {
+		return nil, fmt.Errorf("error to load snapshot: %v", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(config.Dir, journalFileName), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error to open journal: %v", err)
+	}
+	lsn, size, err := db.replay(file, lsn)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error to replay journal: %v", err)
+	}
+
+	db.journal = &journal{
+		config: config,
+		file:   file,
+		size:   size,
+		lsn:    lsn,
+		done:   make(chan struct{}),
+	}
+	if config.Fsync == FsyncInterval {
+		db.journal.every(config.FsyncInterval, func() {
+			if err := file.Sync(); err != nil {
+				logrus.Errorf("error to sync journal: %v", err)
+			}
+		})
+	}
+	if config.SnapshotInterval > 0 {
+		db.journal.every(config.SnapshotInterval, func() {
+			if err := db.Snapshot(); err != nil {
+				logrus.Errorf("error to write snapshot: %v", err)
+			}
+		})
+	}
+
+	return db, nil
+}
+
+// Snapshot writes the whole state to snapshot.json and truncates the journal.
+func (db *InMemoryDB) Snapshot() error {
+	if db.journal == nil {
+		return fmt.Errorf("in-memory db is opened without a data directory")
+	}
+	db.journal.snapshot.Lock()
+	defer db.journal.snapshot.Unlock()
+
+	// Writers append to the journal under the write lock, so holding the read
+	// lock keeps the journal in line with the state we save.
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	snap := snapshot{
+		LSN:         db.journal.lsn,
+		LastSeq:     db.lastSeq,
+		Posts:       make([]storedPost, 0, len(db.Posts)),
+		Comments:    make([]storedComment, 0, len(db.Comments)),
+		Users:       make([]model.User, 0, len(db.Users)),
+		Webhooks:    make([]model.Webhook, 0, len(db.Webhooks)),
+		DeadLetters: make([]model.WebhookDelivery, 0, len(db.DeadLetters)),
+	}
+	for _, post := range db.Posts {
+		snap.Posts = append(snap.Posts, toStoredPost(post))
+	}
+	for _, comment := range db.Comments {
+		snap.Comments = append(snap.Comments, toStoredComment(comment))
+	}
+	for _, user := range db.Users {
+		snap.Users = append(snap.Users, *user)
+	}
+	for _, webhook := range db.Webhooks {
+		snap.Webhooks = append(snap.Webhooks, *webhook)
+	}
+	for _, delivery := range db.DeadLetters {
+		snap.DeadLetters = append(snap.DeadLetters, *delivery)
+	}
+	sort.Slice(snap.Posts, func(i, j int) bool { return snap.Posts[i].ID < snap.Posts[j].ID })
+	sort.Slice(snap.Comments, func(i, j int) bool { return snap.Comments[i].Seq < snap.Comments[j].Seq })
+	sort.Slice(snap.Users, func(i, j int) bool { return snap.Users[i].ID < snap.Users[j].ID })
+	sort.Slice(snap.Webhooks, func(i, j int) bool { return snap.Webhooks[i].ID < snap.Webhooks[j].ID })
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(db.journal.config.Dir, snapshotFileName), data); err != nil {
+		return err
+	}
+
+	// A crash before the truncation is harmless: replay skips entries the snapshot already has.
+	if err := db.journal.file.Truncate(0); err != nil {
+		return fmt.Errorf("error to truncate journal: %v", err)
+	}
+	db.journal.size = 0
+	return db.journal.file.Sync()
+}
+
+// Ping fails once the journal is closed.
+func (db *InMemoryDB) Ping(ctx context.Context) error {
+	if db.journal == nil {
+		return nil
+	}
+
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	if _, err := db.journal.file.Stat(); err != nil {
+		return fmt.Errorf("journal is unavailable: %v", err)
+	}
+	return nil
+}
+
+// Close stops the background flushing and snapshots and closes the journal.
+func (db *InMemoryDB) Close() error {
+	if db.journal == nil {
+		return nil
+	}
+	close(db.journal.done)
+	db.journal.wg.Wait()
+
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	err := db.journal.file.Sync()
+	if closeErr := db.journal.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// record appends the entry to the journal. It is called under the write lock
+// after the change is validated and before it is applied, so nothing is applied
+// that could not be journaled.
+func (db *InMemoryDB) record(entry journalEntry) error {
+	if db.journal == nil {
+		return nil
+	}
+
+	if db.journal.broken != nil {
+		return fmt.Errorf("journal is broken, restart to recover: %v", db.journal.broken)
+	}
+
+	entry.LSN = db.journal.lsn + 1
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error to encode journal entry: %v", err)
+	}
+	n, err := db.journal.file.Write(append(line, '\n'))
+	if err != nil {
+		err = fmt.Errorf("error to write journal: %v", err)
+	} else if db.journal.config.Fsync == FsyncAlways {
+		if err = db.journal.file.Sync(); err != nil {
+			err = fmt.Errorf("error to sync journal: %v", err)
+		}
+	}
+	if err != nil {
+		// The change is not applied, so whatever part of the entry got written is
+		// cut off: it must not be replayed, and the next entry takes its LSN. If
+		// that fails too, the journal no longer matches memory and takes no more
+		// entries.
+		if truncateErr := db.journal.file.Truncate(db.journal.size); truncateErr != nil {
+			db.journal.broken = truncateErr
+		}
+		return err
+	}
+	db.journal.size += int64(n)
+	db.journal.lsn = entry.LSN
+
+	return nil
+}
+
+func (j *journal) every(interval time.Duration, fn func()) {
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-j.done:
+				return
+			}
+		}
+	}()
+}
+
+func (db *InMemoryDB) loadSnapshot(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return 0, err
+	}
+
+	for _, post := range snap.Posts {
+		db.Posts[post.ID] = post.toModel()
+	}
+	// Comments are saved in creation order, which keeps the indexes ordered too.
+	for _, stored := range snap.Comments {
+		comment := stored.toModel()
+		db.Comments[comment.ID] = comment
+		if comment.ParentID != nil {
+			db.Replies[*comment.ParentID] = append(db.Replies[*comment.ParentID], comment.ID)
+		} else {
+			db.PostComments[comment.PostID] = append(db.PostComments[comment.PostID], comment.ID)
+		}
+	}
+	for i := range snap.Users {
+		db.Users[snap.Users[i].ID] = &snap.Users[i]
+	}
+	for i := range snap.Webhooks {
+		db.Webhooks[snap.Webhooks[i].ID] = &snap.Webhooks[i]
+	}
+	for i := range snap.DeadLetters {
+		db.DeadLetters = append(db.DeadLetters, &snap.DeadLetters[i])
+	}
+	db.lastSeq = snap.LastSeq
+
+	return snap.LSN, nil
+}
+
+// replay applies the journal entries newer than lsn and returns the last LSN and
+// the size of the journal. An incomplete last line is left by a crash in the
+// middle of a write, it is cut off.
+func (db *InMemoryDB) replay(file *os.File, lsn int64) (int64, int64, error) {
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				logrus.Warnf("cutting off incomplete journal entry at offset %d", offset)
+				if err := file.Truncate(offset); err != nil {
+					return 0, 0, err
+				}
+			}
+			return lsn, offset, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return 0, 0, fmt.Errorf("invalid journal entry at offset %d: %v", offset, err)
+		}
+		offset += int64(len(line))
+		if entry.LSN <= lsn {
+			continue
+		}
+
+		if err := db.apply(entry); err != nil {
+			return 0, 0, fmt.Errorf("error to apply journal entry %d: %v", entry.LSN, err)
+		}
+		lsn = entry.LSN
+	}
+}
+
+func (db *InMemoryDB) apply(entry journalEntry) error {
+	ctx := context.Background()
+	switch entry.Op {
+	case opCreatePost:
+		return db.CreatePost(ctx, entry.Post.toModel())
+	case opUpdatePost:
+		return db.UpdatePost(ctx, entry.Post.toModel())
+	case opDeletePost:
+		return db.DeletePost(ctx, entry.ID)
+	case opCreateComment:
+		comment := entry.Comment.toModel()
+		if err := db.CreateComment(ctx, &model.Post{ID: comment.PostID}, comment); err != nil {
+			return err
+		}
+		if comment.Seq != entry.Comment.Seq {
+			return fmt.Errorf("comment %s got seq %d instead of %d", comment.ID, comment.Seq, entry.Comment.Seq)
+		}
+		return nil
+	case opUpdateComment:
+		return db.UpdateComment(ctx, entry.Comment.toModel())
+	case opDeleteComment:
+		return db.DeleteComment(ctx, entry.ID)
+	case opSaveUser:
+		return db.SaveUser(ctx, entry.User)
+	case opCreateWebhook:
+		return db.CreateWebhook(ctx, entry.Webhook)
+	case opDeleteWebhook:
+		return db.DeleteWebhook(ctx, entry.ID)
+	case opCreateDeadLetter:
+		return db.CreateDeadLetter(ctx, entry.Delivery)
+	default:
+		return fmt.Errorf("unknown operation: %s", entry.Op)
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func toStoredPost(post *model.Post) storedPost {
+	return storedPost{
+		ID:             post.ID,
+		Title:          post.Title,
+		Body:           post.Body,
+		AllowComments:  post.AllowComments,
+		AuthorID:       post.AuthorID,
+		CreatedAt:      post.CreatedAt,
+		UpdatedAt:      post.UpdatedAt,
+		CommentCount:   post.CommentCount,
+		LastActivityAt: post.LastActivityAt,
+	}
+}
+
+func (p storedPost) toModel() *model.Post {
+	return &model.Post{
+		ID:             p.ID,
+		Title:          p.Title,
+		Body:           p.Body,
+		AllowComments:  p.AllowComments,
+		AuthorID:       p.AuthorID,
+		CreatedAt:      p.CreatedAt,
+		UpdatedAt:      p.UpdatedAt,
+		CommentCount:   p.CommentCount,
+		LastActivityAt: p.LastActivityAt,
+	}
+}
+
+func toStoredComment(comment *model.Comment) storedComment {
+	return storedComment{
+		ID:        comment.ID,
+		PostID:    comment.PostID,
+		Body:      comment.Body,
+		ParentID:  comment.ParentID,
+		AuthorID:  comment.AuthorID,
+		Deleted:   comment.Deleted,
+		Seq:       comment.Seq,
+		CreatedAt: comment.CreatedAt,
+		UpdatedAt: comment.UpdatedAt,
+	}
+}
+
+func (c storedComment) toModel() *model.Comment {
+	return &model.Comment{
+		ID:        c.ID,
+		PostID:    c.PostID,
+		Body:      c.Body,
+		ParentID:  c.ParentID,
+		AuthorID:  c.AuthorID,
+		Deleted:   c.Deleted,
+		Seq:       c.Seq,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+}
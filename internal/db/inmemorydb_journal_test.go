@@ -0,0 +1,194 @@
+package db_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/db/dbtest"
+	"postsandcomments/internal/graph/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openDurableDB(t *testing.T, dir string) *db.InMemoryDB {
+	memoryDB, err := db.OpenInMemoryDB(db.DurabilityConfig{Dir: dir, Fsync: db.FsyncAlways})
+	require.NoError(t, err)
+	return memoryDB
+}
+
+// fillDurableDB makes every kind of change and returns the post with two
+// comments left: a tombstone and its reply.
+func fillDurableDB(t *testing.T, memoryDB *db.InMemoryDB) *model.Post {
+	ctx := context.Background()
+	authorID := "test_user_id"
+
+	require.NoError(t, memoryDB.SaveUser(ctx, &model.User{ID: authorID, Name: "Test User"}))
+	post := &model.Post{ID: "test_post_id", Title: "Test Post", Body: "Test body", AllowComments: true, AuthorID: &authorID}
+	require.NoError(t, memoryDB.CreatePost(ctx, post))
+	removedPost := &model.Post{ID: "removed_post_id", Title: "Removed Post", Body: "Test body"}
+	require.NoError(t, memoryDB.CreatePost(ctx, removedPost))
+
+	parent := &model.Comment{ID: "parent_comment_id", PostID: post.ID, Body: "Parent", AuthorID: &authorID}
+	require.NoError(t, memoryDB.CreateComment(ctx, post, parent))
+	reply := &model.Comment{ID: "reply_comment_id", PostID: post.ID, Body: "Reply", ParentID: &parent.ID}
+	require.NoError(t, memoryDB.CreateComment(ctx, post, reply))
+	removed := &model.Comment{ID: "removed_comment_id", PostID: post.ID, Body: "Removed"}
+	require.NoError(t, memoryDB.CreateComment(ctx, post, removed))
+
+	post.Title = "Updated Post"
+	require.NoError(t, memoryDB.UpdatePost(ctx, post))
+	reply.Body = "Updated reply"
+	require.NoError(t, memoryDB.UpdateComment(ctx, reply))
+	require.NoError(t, memoryDB.DeleteComment(ctx, parent.ID))
+	require.NoError(t, memoryDB.DeleteComment(ctx, removed.ID))
+	require.NoError(t, memoryDB.DeletePost(ctx, removedPost.ID))
+
+	webhook := &model.Webhook{ID: "webhook_id", URL: "https://example.com/webhook", Events: []model.WebhookEvent{model.WebhookEventCommentAdded}, PostID: &post.ID, Secret: "test_secret"}
+	require.NoError(t, memoryDB.CreateWebhook(ctx, webhook))
+	removedWebhook := &model.Webhook{ID: "removed_webhook_id", URL: "https://example.com/removed", Events: []model.WebhookEvent{model.WebhookEventPostAdded}, Secret: "test_secret"}
+	require.NoError(t, memoryDB.CreateWebhook(ctx, removedWebhook))
+	deadLetter := &model.WebhookDelivery{ID: "dead_letter_id", WebhookID: webhook.ID, Event: model.WebhookEventCommentAdded, Payload: "{}", Attempts: 5, LastError: "timeout", FailedAt: time.Now()}
+	require.NoError(t, memoryDB.CreateDeadLetter(ctx, deadLetter))
+	require.NoError(t, memoryDB.DeleteWebhook(ctx, removedWebhook.ID))
+
+	return post
+}
+
+func assertDurableDBRestored(t *testing.T, memoryDB *db.InMemoryDB) {
+	ctx := context.Background()
+
+	posts, err := memoryDB.GetPosts(ctx, db.PostQuery{First: 10})
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, "Updated Post", posts[0].Title)
+	assert.Equal(t, 1, posts[0].CommentCount)
+	require.NotNil(t, posts[0].AuthorID)
+	assert.Equal(t, "test_user_id", *posts[0].AuthorID)
+
+	user, err := memoryDB.GetUserById(ctx, "test_user_id")
+	require.NoError(t, err)
+	assert.Equal(t, "Test User", user.Name)
+
+	webhooks, err := memoryDB.GetWebhooks(ctx)
+	require.NoError(t, err)
+	require.Len(t, webhooks, 1)
+	assert.Equal(t, "webhook_id", webhooks[0].ID)
+	assert.Equal(t, []model.WebhookEvent{model.WebhookEventCommentAdded}, webhooks[0].Events)
+	deadLetters, err := memoryDB.GetDeadLetters(ctx, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, "dead_letter_id", deadLetters[0].ID)
+
+	comments, err := memoryDB.GetComments(ctx, posts[0].ID, nil, 10, nil)
+	require.NoError(t, err)
+	require.Len(t, comments, 1)
+	assert.True(t, comments[0].Deleted)
+	assert.Equal(t, db.DeletedCommentBody, comments[0].Body)
+	assert.Nil(t, comments[0].AuthorID)
+	require.Len(t, comments[0].Children, 1)
+	assert.Equal(t, "Updated reply", comments[0].Children[0].Body)
+
+	// Seq keeps growing after restart, so new comments sort after the old ones.
+	comment := &model.Comment{ID: "new_comment_id", PostID: posts[0].ID, Body: "New"}
+	require.NoError(t, memoryDB.CreateComment(ctx, posts[0], comment))
+	assert.Greater(t, comment.Seq, comments[0].Children[0].Seq)
+}
+
+func TestInMemoryJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	memoryDB := openDurableDB(t, dir)
+	fillDurableDB(t, memoryDB)
+	require.NoError(t, memoryDB.Close())
+
+	memoryDB = openDurableDB(t, dir)
+	defer memoryDB.Close()
+	assertDurableDBRestored(t, memoryDB)
+}
+
+func TestInMemorySnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	memoryDB := openDurableDB(t, dir)
+	fillDurableDB(t, memoryDB)
+	require.NoError(t, memoryDB.Snapshot())
+	require.NoError(t, memoryDB.Close())
+
+	info, err := os.Stat(filepath.Join(dir, "journal.log"))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	memoryDB = openDurableDB(t, dir)
+	defer memoryDB.Close()
+	assertDurableDBRestored(t, memoryDB)
+}
+
+func TestInMemorySnapshotWithStaleJournal(t *testing.T) {
+	dir := t.TempDir()
+
+	memoryDB := openDurableDB(t, dir)
+	fillDurableDB(t, memoryDB)
+	require.NoError(t, memoryDB.Close())
+	journal, err := os.ReadFile(filepath.Join(dir, "journal.log"))
+	require.NoError(t, err)
+
+	memoryDB = openDurableDB(t, dir)
+	require.NoError(t, memoryDB.Snapshot())
+	require.NoError(t, memoryDB.Close())
+
+	// A crash between writing the snapshot and truncating the journal leaves
+	// entries that are already in the snapshot, they must not be applied twice.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "journal.log"), journal, 0o644))
+
+	memoryDB = openDurableDB(t, dir)
+	defer memoryDB.Close()
+	assertDurableDBRestored(t, memoryDB)
+}
+
+func TestInMemoryJournalWithIncompleteEntry(t *testing.T) {
+	dir := t.TempDir()
+
+	memoryDB := openDurableDB(t, dir)
+	fillDurableDB(t, memoryDB)
+	require.NoError(t, memoryDB.Close())
+
+	journal, err := os.OpenFile(filepath.Join(dir, "journal.log"), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = journal.WriteString(`{"lsn":100,"op":"createPo`)
+	require.NoError(t, err)
+	require.NoError(t, journal.Close())
+
+	memoryDB = openDurableDB(t, dir)
+	defer memoryDB.Close()
+	assertDurableDBRestored(t, memoryDB)
+}
+
+func TestOpenInMemoryDBWithInvalidFsyncPolicy(t *testing.T) {
+	_, err := db.OpenInMemoryDB(db.DurabilityConfig{Dir: t.TempDir(), Fsync: "sometimes"})
+	assert.Error(t, err)
+
+	_, err = db.OpenInMemoryDB(db.DurabilityConfig{Dir: t.TempDir(), Fsync: db.FsyncInterval})
+	assert.Error(t, err)
+}
+
+func TestDurableInMemoryConformance(t *testing.T) {
+	dbtest.RunConformance(t, func() db.Database {
+		memoryDB, err := db.OpenInMemoryDB(db.DurabilityConfig{Dir: t.TempDir(), Fsync: db.FsyncNever})
+		require.NoError(t, err)
+		t.Cleanup(func() { memoryDB.Close() })
+		return memoryDB
+	})
+}
+
+func TestInMemoryPingAfterClose(t *testing.T) {
+	memoryDB := openDurableDB(t, t.TempDir())
+	assert.NoError(t, memoryDB.Ping(context.Background()))
+
+	require.NoError(t, memoryDB.Close())
+	assert.Error(t, memoryDB.Ping(context.Background()))
+}
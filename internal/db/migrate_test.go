@@ -0,0 +1,67 @@
+package db_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"postsandcomments/internal/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_second.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+		"0002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+		"0001_first.up.sql":    {Data: []byte("CREATE TABLE a ();")},
+		"0001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+	}
+
+	migrations, err := db.LoadMigrations(fsys)
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, int64(1), migrations[0].Version)
+	assert.Equal(t, "first", migrations[0].Name)
+	assert.Equal(t, "CREATE TABLE a ();", migrations[0].Up)
+	assert.Equal(t, "DROP TABLE a;", migrations[0].Down)
+	assert.Equal(t, int64(2), migrations[1].Version)
+}
+
+func TestLoadMigrationsWithoutDownFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_first.up.sql": {Data: []byte("CREATE TABLE a ();")},
+	}
+
+	_, err := db.LoadMigrations(fsys)
+	assert.Error(t, err)
+}
+
+func TestLoadMigrationsWithDuplicateVersion(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_first.up.sql":   {Data: []byte("CREATE TABLE a ();")},
+		"0001_first.down.sql": {Data: []byte("DROP TABLE a;")},
+		"0001_other.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+		"0001_other.down.sql": {Data: []byte("DROP TABLE b;")},
+	}
+
+	_, err := db.LoadMigrations(fsys)
+	assert.Error(t, err)
+}
+
+func TestLoadMigrationsWithInvalidName(t *testing.T) {
+	fsys := fstest.MapFS{
+		"first.sql": {Data: []byte("CREATE TABLE a ();")},
+	}
+
+	_, err := db.LoadMigrations(fsys)
+	assert.Error(t, err)
+}
+
+func TestEmbeddedPostgresMigrations(t *testing.T) {
+	migrations, err := db.LoadMigrations(db.PostgresMigrations)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+	for i := 1; i < len(migrations); i++ {
+		assert.Less(t, migrations[i-1].Version, migrations[i].Version)
+	}
+}
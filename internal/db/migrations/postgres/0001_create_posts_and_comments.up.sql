@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS posts (
+	id UUID PRIMARY KEY,
+	title TEXT NOT NULL,
+	body TEXT NOT NULL,
+	allow_comments BOOLEAN NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+	id UUID PRIMARY KEY,
+	post_id UUID REFERENCES posts(id),
+	body VARCHAR(2000) NOT NULL,
+	parent_id UUID,
+	FOREIGN KEY (parent_id) REFERENCES comments (id)
+);
@@ -0,0 +1,4 @@
+ALTER TABLE comments DROP COLUMN author_id;
+ALTER TABLE posts DROP COLUMN author_id;
+
+DROP TABLE users;
@@ -0,0 +1,7 @@
+CREATE TABLE users (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL
+);
+
+ALTER TABLE posts ADD COLUMN author_id TEXT REFERENCES users (id);
+ALTER TABLE comments ADD COLUMN author_id TEXT REFERENCES users (id);
@@ -0,0 +1,3 @@
+ALTER TABLE comments ADD COLUMN seq BIGSERIAL;
+
+CREATE INDEX comments_post_id_parent_id_seq_idx ON comments (post_id, parent_id, seq);
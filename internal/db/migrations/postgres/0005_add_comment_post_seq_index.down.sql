@@ -0,0 +1 @@
+DROP INDEX comments_post_id_seq_idx;
@@ -0,0 +1,6 @@
+CREATE TABLE rate_limits (
+	key TEXT PRIMARY KEY,
+	tat TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX rate_limits_tat_idx ON rate_limits (tat);
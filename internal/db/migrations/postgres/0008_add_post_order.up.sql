@@ -0,0 +1,14 @@
+-- Existing posts and comments get the time of the migration.
+ALTER TABLE posts ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE posts ADD COLUMN comment_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN last_activity_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE comments ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+UPDATE posts SET comment_count = (
+	SELECT count(*) FROM comments WHERE comments.post_id = posts.id AND NOT comments.deleted
+);
+
+CREATE INDEX posts_created_at_id_idx ON posts (created_at, id);
+CREATE INDEX posts_comment_count_created_at_id_idx ON posts (comment_count, created_at, id);
+CREATE INDEX posts_last_activity_at_created_at_id_idx ON posts (last_activity_at, created_at, id);
+CREATE INDEX posts_author_id_created_at_id_idx ON posts (author_id, created_at, id);
@@ -0,0 +1,6 @@
+-- Existing posts and comments count as not edited since they were created.
+ALTER TABLE posts ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE comments ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+UPDATE posts SET updated_at = created_at;
+UPDATE comments SET updated_at = created_at;
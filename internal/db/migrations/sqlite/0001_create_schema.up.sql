@@ -0,0 +1,26 @@
+CREATE TABLE users (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL
+);
+
+CREATE TABLE posts (
+	id TEXT PRIMARY KEY,
+	title TEXT NOT NULL,
+	body TEXT NOT NULL,
+	allow_comments BOOLEAN NOT NULL,
+	author_id TEXT REFERENCES users (id)
+);
+
+-- seq is the rowid, AUTOINCREMENT keeps it growing even after the last comment is deleted.
+CREATE TABLE comments (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	id TEXT NOT NULL UNIQUE,
+	post_id TEXT NOT NULL REFERENCES posts (id),
+	body VARCHAR(2000) NOT NULL,
+	parent_id TEXT REFERENCES comments (id),
+	author_id TEXT REFERENCES users (id),
+	deleted BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX comments_post_id_parent_id_seq_idx ON comments (post_id, parent_id, seq);
+CREATE INDEX comments_parent_id_idx ON comments (parent_id);
@@ -0,0 +1 @@
+CREATE INDEX comments_post_id_seq_idx ON comments (post_id, seq);
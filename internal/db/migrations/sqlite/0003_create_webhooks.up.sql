@@ -0,0 +1,21 @@
+-- events is a JSON array.
+CREATE TABLE webhooks (
+	id TEXT PRIMARY KEY,
+	url TEXT NOT NULL,
+	events TEXT NOT NULL,
+	post_id TEXT,
+	secret TEXT NOT NULL
+);
+
+CREATE TABLE webhook_dead_letters (
+	id TEXT PRIMARY KEY,
+	webhook_id TEXT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	event TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL,
+	last_error TEXT NOT NULL,
+	failed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX webhook_dead_letters_failed_at_idx ON webhook_dead_letters (failed_at);
+CREATE INDEX webhook_dead_letters_webhook_id_failed_at_idx ON webhook_dead_letters (webhook_id, failed_at);
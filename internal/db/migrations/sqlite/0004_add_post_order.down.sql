@@ -0,0 +1,8 @@
+DROP INDEX posts_author_id_created_at_id_idx;
+DROP INDEX posts_last_activity_at_created_at_id_idx;
+DROP INDEX posts_comment_count_created_at_id_idx;
+DROP INDEX posts_created_at_id_idx;
+ALTER TABLE comments DROP COLUMN created_at;
+ALTER TABLE posts DROP COLUMN last_activity_at;
+ALTER TABLE posts DROP COLUMN comment_count;
+ALTER TABLE posts DROP COLUMN created_at;
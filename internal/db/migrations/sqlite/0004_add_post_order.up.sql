@@ -0,0 +1,20 @@
+-- Timestamps are compared as text, so they are stored in UTC in the format
+-- the driver writes time.Time in. Existing posts and comments get the time
+-- of the migration, the defaults only fill the columns until then.
+ALTER TABLE posts ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00 +0000 UTC';
+ALTER TABLE posts ADD COLUMN comment_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN last_activity_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00 +0000 UTC';
+ALTER TABLE comments ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00 +0000 UTC';
+
+UPDATE posts SET
+	created_at = strftime('%Y-%m-%d %H:%M:%S +0000 UTC', 'now'),
+	last_activity_at = strftime('%Y-%m-%d %H:%M:%S +0000 UTC', 'now'),
+	comment_count = (
+		SELECT count(*) FROM comments WHERE comments.post_id = posts.id AND NOT comments.deleted
+	);
+UPDATE comments SET created_at = strftime('%Y-%m-%d %H:%M:%S +0000 UTC', 'now');
+
+CREATE INDEX posts_created_at_id_idx ON posts (created_at, id);
+CREATE INDEX posts_comment_count_created_at_id_idx ON posts (comment_count, created_at, id);
+CREATE INDEX posts_last_activity_at_created_at_id_idx ON posts (last_activity_at, created_at, id);
+CREATE INDEX posts_author_id_created_at_id_idx ON posts (author_id, created_at, id);
@@ -0,0 +1,6 @@
+-- Existing posts and comments count as not edited since they were created.
+ALTER TABLE posts ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00 +0000 UTC';
+ALTER TABLE comments ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00 +0000 UTC';
+
+UPDATE posts SET updated_at = created_at;
+UPDATE comments SET updated_at = created_at;
@@ -3,70 +3,85 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
 	"time"
 
 	"postsandcomments/internal/graph/model"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
 )
 
 const numOfAttempts = 10
 
+// PostgresDriver is the database/sql driver Postgres is opened with, tracing
+// replaces it with a driver that records statements.
+var PostgresDriver = "postgres"
+
 type PostgresDB struct {
-	DB *sql.DB
+	DB       *sql.DB
+	MaxDepth int
 }
 
-func NewPostgresDB(host string, port int, user, password string) (*PostgresDB, error) {
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s sslmode=disable", host, port, user, password)
-
-	db, err := connectToDB(psqlInfo)
+func NewPostgresDB(ctx context.Context, host string, port int, user, password string) (*PostgresDB, error) {
+	db, err := OpenPostgres(ctx, host, port, user, password)
 	if err != nil {
 		return nil, fmt.Errorf("error to create postgres db: %v", err)
 	}
-	
-	_, err = db.Exec(`
-		DROP TABLE IF EXISTS comments;
-		DROP TABLE IF EXISTS posts;
 
-		CREATE TABLE posts (
-			id UUID PRIMARY KEY,
-			title TEXT NOT NULL,
-			body TEXT NOT NULL,
-			allow_comments BOOLEAN NOT NULL
-		);
+	migrator, err := NewPostgresMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error to load migrations: %v", err)
+	}
 
-		CREATE TABLE comments (
-			id UUID PRIMARY KEY,
-			post_id UUID REFERENCES posts(id),
-			body VARCHAR(2000) NOT NULL,
-			parent_id UUID,
-			FOREIGN KEY (parent_id) REFERENCES comments (id)
-		);
-	`)
+	applied, err := migrator.Up(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error to create tables: %v", err)
+		db.Close()
+		return nil, fmt.Errorf("error to migrate postgres db: %v", err)
+	}
+	for _, migration := range applied {
+		logrus.Infof("applied migration %d_%s", migration.Version, migration.Name)
 	}
 
 	return &PostgresDB{DB: db}, nil
 }
 
+// OpenPostgres waits for the database to come up, it gives up after
+// numOfAttempts or when ctx is done.
+func OpenPostgres(ctx context.Context, host string, port int, user, password string) (*sql.DB, error) {
+	return connectToDB(ctx, PostgresConnInfo(host, port, user, password))
+}
+
+func PostgresConnInfo(host string, port int, user, password string) string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s sslmode=disable", host, port, user, password)
+}
+
 func (db *PostgresDB) CreatePost(ctx context.Context, post *model.Post) error {
-	query := `INSERT INTO posts (id, title, body, allow_comments) VALUES ($1, $2, $3, $4)`
-	_, err := db.DB.ExecContext(ctx, query, post.ID, post.Title, post.Body, post.AllowComments)
-	return err
+	query := `
+		INSERT INTO posts (id, title, body, allow_comments, author_id, created_at, updated_at, last_activity_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $6)
+	`
+	_, err := db.DB.ExecContext(ctx, query, post.ID, post.Title, post.Body, post.AllowComments, post.AuthorID, post.CreatedAt, post.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	post.CommentCount = 0
+	post.LastActivityAt = post.CreatedAt
+	return nil
 }
 
-func (db *PostgresDB) GetPosts(ctx context.Context) ([]*model.Post, error) {
-	rows, err := db.DB.QueryContext(ctx, "SELECT id, title, body, allow_comments FROM posts")
+func (db *PostgresDB) GetPosts(ctx context.Context, query PostQuery) ([]*model.Post, error) {
+	statement, args := postsSQL(query)
+	rows, err := db.DB.QueryContext(ctx, statement, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var posts []*model.Post
+	posts := make([]*model.Post, 0, query.First)
 	for rows.Next() {
 		var post model.Post
 		err := rows.Scan(
@@ -74,9 +89,14 @@ func (db *PostgresDB) GetPosts(ctx context.Context) ([]*model.Post, error) {
 			&post.Title,
 			&post.Body,
 			&post.AllowComments,
+			&post.AuthorID,
+			inUTC(&post.CreatedAt),
+			inUTC(&post.UpdatedAt),
+			&post.CommentCount,
+			inUTC(&post.LastActivityAt),
 		)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		posts = append(posts, &post)
 	}
@@ -88,8 +108,9 @@ func (db *PostgresDB) GetPosts(ctx context.Context) ([]*model.Post, error) {
 	return posts, nil
 }
 
-func (db *PostgresDB) GetPostById(ctx context.Context, id string, limit *int, offset *int) (*model.Post, error) {
-	row := db.DB.QueryRowContext(ctx, "SELECT id, title, body, allow_comments FROM posts WHERE id=$1", id)
+func (db *PostgresDB) GetPostById(ctx context.Context, id string) (*model.Post, error) {
+	query := "SELECT id, title, body, allow_comments, author_id, created_at, updated_at, comment_count, last_activity_at FROM posts WHERE id=$1"
+	row := db.DB.QueryRowContext(ctx, query, id)
 	var post model.Post
 
 	err := row.Scan(
@@ -97,22 +118,55 @@ func (db *PostgresDB) GetPostById(ctx context.Context, id string, limit *int, of
 		&post.Title,
 		&post.Body,
 		&post.AllowComments,
+		&post.AuthorID,
+		inUTC(&post.CreatedAt),
+		inUTC(&post.UpdatedAt),
+		&post.CommentCount,
+		inUTC(&post.LastActivityAt),
 	)
+	if err == sql.ErrNoRows || invalidUUID(err) {
+		return nil, notFound("posts", id)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	comments, err := db.GetComments(ctx, id, limit, offset)
+	return &post, nil
+}
+
+func (db *PostgresDB) UpdatePost(ctx context.Context, post *model.Post) error {
+	query := `UPDATE posts SET title = $2, body = $3, allow_comments = $4, updated_at = $5 WHERE id = $1`
+	result, err := db.DB.ExecContext(ctx, query, post.ID, post.Title, post.Body, post.AllowComments, post.UpdatedAt)
 	if err != nil {
-		return nil, fmt.Errorf("error to get comments: %v", err)
+		return err
 	}
-	post.Comments = comments
 
-	return &post, nil
+	return expectAffected(result, "posts", post.ID)
+}
+
+func (db *PostgresDB) DeletePost(ctx context.Context, id string) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM comments WHERE post_id = $1", id); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM posts WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if err := expectAffected(result, "posts", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (db *PostgresDB) GetCommentById(ctx context.Context, id string) (*model.Comment, error) {
-	row := db.DB.QueryRowContext(ctx, "SELECT id, post_id, body, parent_id FROM comments WHERE id=$1", id)
+	row := db.DB.QueryRowContext(ctx, "SELECT id, post_id, body, parent_id, author_id, deleted, seq, created_at, updated_at FROM comments WHERE id=$1", id)
 	var comment model.Comment
 
 	err := row.Scan(
@@ -120,81 +174,560 @@ func (db *PostgresDB) GetCommentById(ctx context.Context, id string) (*model.Com
 		&comment.PostID,
 		&comment.Body,
 		&comment.ParentID,
+		&comment.AuthorID,
+		&comment.Deleted,
+		&comment.Seq,
+		inUTC(&comment.CreatedAt),
+		inUTC(&comment.UpdatedAt),
 	)
+	if err == sql.ErrNoRows || invalidUUID(err) {
+		return nil, notFound("comments", id)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	err = db.fillChildren(ctx, []*model.Comment{&comment})
+	if err != nil {
+		return nil, fmt.Errorf("error to get replies: %v", err)
+	}
+
 	return &comment, nil
 }
 
+// CreateComment locks the post before the comment takes its seq, so comments
+// of a post commit in seq order. Sequence values are handed out at insert time:
+// without the lock a reader could see seq N+1 while N is not committed yet, page
+// past it with a cursor and never get N.
 func (db *PostgresDB) CreateComment(ctx context.Context, post *model.Post, comment *model.Comment) error {
-	query := `INSERT INTO comments (id, post_id, body, parent_id) VALUES ($1, $2, $3, $4)`
-	_, err := db.DB.ExecContext(ctx, query, comment.ID, post.ID, comment.Body, comment.ParentID)
-	return err
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE posts SET comment_count = comment_count + 1, last_activity_at = GREATEST(last_activity_at, $2) WHERE id = $1`
+	result, err := tx.ExecContext(ctx, query, post.ID, comment.CreatedAt)
+	if invalidUUID(err) {
+		return notFound("posts", post.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if err := expectAffected(result, "posts", post.ID); err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO comments (id, post_id, body, parent_id, author_id, created_at, updated_at)
+		SELECT $1::uuid, $2::uuid, $3::text, $4::uuid, $5::text, $6::timestamptz, $7::timestamptz
+		WHERE $4::uuid IS NULL OR EXISTS (SELECT 1 FROM comments WHERE id = $4 AND post_id = $2)
+		RETURNING seq
+	`
+	row := tx.QueryRowContext(ctx, query, comment.ID, post.ID, comment.Body, comment.ParentID, comment.AuthorID, comment.CreatedAt, comment.UpdatedAt)
+	err = row.Scan(&comment.Seq)
+	if err == sql.ErrNoRows {
+		// The parent is missing or belongs to another post.
+		var exists bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM comments WHERE id = $1)", *comment.ParentID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("%w: %s", ErrParentPostMismatch, *comment.ParentID)
+		}
+		return notFound("comments", *comment.ParentID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *PostgresDB) UpdateComment(ctx context.Context, comment *model.Comment) error {
+	result, err := db.DB.ExecContext(ctx, "UPDATE comments SET body = $2, updated_at = $3 WHERE id = $1", comment.ID, comment.Body, comment.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result, "comments", comment.ID)
+}
+
+func (db *PostgresDB) DeleteComment(ctx context.Context, id string) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// CreateComment locks the post before it takes locks on comments, the post
+	// is locked first here as well, so the two cannot deadlock.
+	var postID string
+	err = tx.QueryRowContext(ctx, "SELECT post_id FROM comments WHERE id = $1", id).Scan(&postID)
+	if err == sql.ErrNoRows || invalidUUID(err) {
+		return notFound("comments", id)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "SELECT 1 FROM posts WHERE id = $1 FOR NO KEY UPDATE", postID)
+	if err != nil {
+		return err
+	}
+
+	// Locking the comment makes concurrent replies to it wait until we decide
+	// whether it is removed or kept as a tombstone.
+	var parentID *string
+	var deleted bool
+	err = tx.QueryRowContext(ctx, "SELECT parent_id, deleted FROM comments WHERE id = $1 FOR UPDATE", id).Scan(&parentID, &deleted)
+	if err == sql.ErrNoRows {
+		return notFound("comments", id)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Deletions of siblings wait for each other on the parent, so the last one
+	// sees that the others are gone and can remove an empty tombstone parent.
+	if parentID != nil {
+		_, err = tx.ExecContext(ctx, "SELECT 1 FROM comments WHERE id = $1 FOR UPDATE", *parentID)
+		if err != nil {
+			return err
+		}
+	}
+
+	var hasReplies bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM comments WHERE parent_id = $1)", id).Scan(&hasReplies)
+	if err != nil {
+		return err
+	}
+
+	if hasReplies {
+		query := `UPDATE comments SET body = $2, deleted = TRUE, author_id = NULL WHERE id = $1`
+		_, err = tx.ExecContext(ctx, query, id, DeletedCommentBody)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM comments WHERE id = $1", id)
+		if err == nil {
+			err = deleteEmptyTombstones(ctx, tx, parentID, " FOR UPDATE")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// A tombstone is not counted already.
+	if !deleted {
+		_, err = tx.ExecContext(ctx, "UPDATE posts SET comment_count = comment_count - 1 WHERE id = $1", postID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (db *PostgresDB) GetComments(ctx context.Context, postId string, limit *int, offset *int) ([]*model.Comment, error) {
+func (db *PostgresDB) GetComments(ctx context.Context, postId string, parentId *string, first int, after *int64) ([]*model.Comment, error) {
+	var afterSeq int64
+	if after != nil {
+		afterSeq = *after
+	}
+
 	query := `
-        WITH RECURSIVE comment_tree AS (
-            SELECT
-                id,
-                post_id,
-                body,
-                parent_id
-            FROM comments
-            WHERE post_id =  $1 AND parent_id IS NULL
-
-            UNION ALL
-
-            SELECT
-                c.id,
-                c.post_id,
-                c.body,
-                c.parent_id
-            FROM comments c
-            INNER JOIN comment_tree ct ON c.parent_id = ct.id
-        )
-        SELECT id, post_id, body, parent_id FROM comment_tree LIMIT $2 OFFSET $3;
-    `
-	rows, err := db.DB.QueryContext(ctx, query, postId, limit, offset)
+		SELECT id, post_id, body, parent_id, author_id, deleted, seq, created_at, updated_at
+		FROM comments
+		WHERE post_id = $1 AND parent_id IS NULL AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`
+	args := []interface{}{postId, afterSeq, first}
+	if parentId != nil {
+		query = `
+			SELECT id, post_id, body, parent_id, author_id, deleted, seq, created_at, updated_at
+			FROM comments
+			WHERE post_id = $1 AND parent_id = $4 AND seq > $2
+			ORDER BY seq
+			LIMIT $3
+		`
+		args = append(args, *parentId)
+	}
+
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if invalidUUID(err) {
+		return []*model.Comment{}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var comments []*model.Comment
-
+	comments := make([]*model.Comment, 0, first)
 	for rows.Next() {
 		var comment model.Comment
-		if err := rows.Scan(&comment.ID, &comment.PostID, &comment.Body, &comment.ParentID); err != nil {
+		err := rows.Scan(
+			&comment.ID,
+			&comment.PostID,
+			&comment.Body,
+			&comment.ParentID,
+			&comment.AuthorID,
+			&comment.Deleted,
+			&comment.Seq,
+			inUTC(&comment.CreatedAt),
+			inUTC(&comment.UpdatedAt),
+		)
+		if err != nil {
 			return nil, err
 		}
 		comments = append(comments, &comment)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = db.fillChildren(ctx, comments)
+	if err != nil {
+		return nil, fmt.Errorf("error to get replies: %v", err)
+	}
 
 	return comments, nil
 }
 
-func connectToDB(psqlInfo string) (*sql.DB, error) {
-	var db *sql.DB
-    var err error
-	for attempt := 1; attempt <= numOfAttempts; attempt++{
+func (db *PostgresDB) GetCommentsSince(ctx context.Context, postId string, afterSeq int64, limit int) ([]*model.Comment, error) {
+	query := `
+		SELECT id, post_id, body, parent_id, author_id, deleted, seq, created_at, updated_at
+		FROM comments
+		WHERE post_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`
+	rows, err := db.DB.QueryContext(ctx, query, postId, afterSeq, limit)
+	if invalidUUID(err) {
+		return []*model.Comment{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := make([]*model.Comment, 0)
+	for rows.Next() {
+		var comment model.Comment
+		err := rows.Scan(
+			&comment.ID,
+			&comment.PostID,
+			&comment.Body,
+			&comment.ParentID,
+			&comment.AuthorID,
+			&comment.Deleted,
+			&comment.Seq,
+			inUTC(&comment.CreatedAt),
+			inUTC(&comment.UpdatedAt),
+		)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, &comment)
+	}
+
+	return comments, rows.Err()
+}
+
+// fillChildren loads replies to the comments into their Children, at most MaxDepth levels deep.
+func (db *PostgresDB) fillChildren(ctx context.Context, comments []*model.Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(comments))
+	byId := make(map[string]*model.Comment, len(comments))
+	for _, comment := range comments {
+		ids = append(ids, comment.ID)
+		byId[comment.ID] = comment
+	}
+
+	query := `
+		WITH RECURSIVE comment_tree AS (
+			SELECT id, post_id, body, parent_id, author_id, deleted, seq, created_at, updated_at, 1 AS depth
+			FROM comments
+			WHERE parent_id = ANY($1::uuid[])
+
+			UNION ALL
+
+			SELECT c.id, c.post_id, c.body, c.parent_id, c.author_id, c.deleted, c.seq, c.created_at, c.updated_at, ct.depth + 1
+			FROM comments c
+			INNER JOIN comment_tree ct ON c.parent_id = ct.id
+			WHERE ct.depth < $2
+		)
+		SELECT id, post_id, body, parent_id, author_id, deleted, seq, created_at, updated_at FROM comment_tree ORDER BY seq
+	`
+	rows, err := db.DB.QueryContext(ctx, query, pq.Array(ids), maxDepth(db.MaxDepth))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Replies are always created after their parents, so ordering by seq
+	// puts every parent before its children.
+	for rows.Next() {
+		var comment model.Comment
+		err := rows.Scan(
+			&comment.ID,
+			&comment.PostID,
+			&comment.Body,
+			&comment.ParentID,
+			&comment.AuthorID,
+			&comment.Deleted,
+			&comment.Seq,
+			inUTC(&comment.CreatedAt),
+			inUTC(&comment.UpdatedAt),
+		)
+		if err != nil {
+			return err
+		}
+
+		parent, exists := byId[*comment.ParentID]
+		if !exists {
+			continue
+		}
+		parent.Children = append(parent.Children, &comment)
+		byId[comment.ID] = &comment
+	}
+
+	return rows.Err()
+}
+
+func (db *PostgresDB) SaveUser(ctx context.Context, user *model.User) error {
+	query := `INSERT INTO users (id, name) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name`
+	_, err := db.DB.ExecContext(ctx, query, user.ID, user.Name)
+	return err
+}
+
+func (db *PostgresDB) GetUserById(ctx context.Context, id string) (*model.User, error) {
+	row := db.DB.QueryRowContext(ctx, "SELECT id, name FROM users WHERE id=$1", id)
+	var user model.User
+
+	err := row.Scan(&user.ID, &user.Name)
+	if err == sql.ErrNoRows {
+		return nil, notFound("users", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (db *PostgresDB) GetUsersByIds(ctx context.Context, ids []string) ([]*model.User, error) {
+	rows, err := db.DB.QueryContext(ctx, "SELECT id, name FROM users WHERE id = ANY($1::text[])", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanUsers(rows)
+}
+
+func scanUsers(rows *sql.Rows) ([]*model.User, error) {
+	users := make([]*model.User, 0)
+	for rows.Next() {
+		var user model.User
+		if err := rows.Scan(&user.ID, &user.Name); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+func (db *PostgresDB) Ping(ctx context.Context) error {
+	return db.DB.PingContext(ctx)
+}
+
+func (db *PostgresDB) Close() error {
+	return db.DB.Close()
+}
+
+func (db *PostgresDB) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	query := `INSERT INTO webhooks (id, url, events, post_id, secret) VALUES ($1, $2, $3, $4, $5)`
+	_, err := db.DB.ExecContext(ctx, query, webhook.ID, webhook.URL, pq.Array(eventNames(webhook.Events)), webhook.PostID, webhook.Secret)
+	return err
+}
+
+func (db *PostgresDB) GetWebhooks(ctx context.Context) ([]*model.Webhook, error) {
+	rows, err := db.DB.QueryContext(ctx, "SELECT id, url, events, post_id, secret FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]*model.Webhook, 0)
+	for rows.Next() {
+		var webhook model.Webhook
+		var events []string
+		if err := rows.Scan(&webhook.ID, &webhook.URL, pq.Array(&events), &webhook.PostID, &webhook.Secret); err != nil {
+			return nil, err
+		}
+		webhook.Events = webhookEvents(events)
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (db *PostgresDB) DeleteWebhook(ctx context.Context, id string) error {
+	result, err := db.DB.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1", id)
+	if invalidUUID(err) {
+		return notFound("webhooks", id)
+	}
+	if err != nil {
+		return err
+	}
+	return expectAffected(result, "webhooks", id)
+}
+
+func (db *PostgresDB) CreateDeadLetter(ctx context.Context, delivery *model.WebhookDelivery) error {
+	query := `INSERT INTO webhook_dead_letters (id, webhook_id, event, payload, attempts, last_error, failed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := db.DB.ExecContext(ctx, query, delivery.ID, delivery.WebhookID, string(delivery.Event), delivery.Payload, delivery.Attempts, delivery.LastError, delivery.FailedAt)
+	return err
+}
+
+func (db *PostgresDB) GetDeadLetters(ctx context.Context, webhookID *string, limit int) ([]*model.WebhookDelivery, error) {
+	query := `SELECT id, webhook_id, event, payload, attempts, last_error, failed_at FROM webhook_dead_letters
+		WHERE $1::uuid IS NULL OR webhook_id = $1
+		ORDER BY failed_at DESC
+		LIMIT $2`
+	rows, err := db.DB.QueryContext(ctx, query, webhookID, limit)
+	if invalidUUID(err) {
+		return []*model.WebhookDelivery{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDeadLetters(rows)
+}
+
+func scanDeadLetters(rows *sql.Rows) ([]*model.WebhookDelivery, error) {
+	deliveries := make([]*model.WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery model.WebhookDelivery
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Attempts,
+			&delivery.LastError,
+			&delivery.FailedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func eventNames(events []model.WebhookEvent) []string {
+	names := make([]string, len(events))
+	for i, event := range events {
+		names[i] = string(event)
+	}
+	return names
+}
+
+func webhookEvents(names []string) []model.WebhookEvent {
+	events := make([]model.WebhookEvent, len(names))
+	for i, name := range names {
+		events[i] = model.WebhookEvent(name)
+	}
+	return events
+}
+
+// invalidUUID reports whether Postgres rejected an id that is not a UUID,
+// no post or comment can have it.
+func invalidUUID(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "22P02"
+}
+
+// deleteEmptyTombstones removes the parent of a deleted comment if it is a
+// tombstone without replies left, and so on up the thread. Tombstones are
+// kept only to hold replies. lock is appended to the query that reads an
+// ancestor, SQLite has no row locks and passes an empty string.
+func deleteEmptyTombstones(ctx context.Context, tx *sql.Tx, parentID *string, lock string) error {
+	for parentID != nil {
+		// Each ancestor is locked before its replies are checked. The check is a
+		// statement of its own, so under READ COMMITTED it sees the replies that
+		// were deleted by whoever held the lock before.
+		var deleted bool
+		var grandparentID *string
+		err := tx.QueryRowContext(ctx, "SELECT deleted, parent_id FROM comments WHERE id = $1"+lock, *parentID).Scan(&deleted, &grandparentID)
+		if err == sql.ErrNoRows || (err == nil && !deleted) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var hasReplies bool
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM comments WHERE parent_id = $1)", *parentID).Scan(&hasReplies)
+		if err != nil || hasReplies {
+			return err
+		}
+
+		if _, err = tx.ExecContext(ctx, "DELETE FROM comments WHERE id = $1", *parentID); err != nil {
+			return err
+		}
+		parentID = grandparentID
+	}
+	return nil
+}
+
+func expectAffected(result sql.Result, kind string, id string) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound(kind, id)
+	}
+	return nil
+}
+
+func connectToDB(ctx context.Context, psqlInfo string) (*sql.DB, error) {
+	for attempt := 1; attempt <= numOfAttempts; attempt++ {
 		logrus.Infof("waiting for inicialization of db, attempt %d", attempt)
-		time.Sleep(time.Second)
-        db, err = sql.Open("postgres", psqlInfo)
-        if err != nil {
-            logrus.Errorf("failed to open database connection: %v", err)
-            continue
-        }
-
-        err = db.Ping()
-        if err != nil {
-            logrus.Errorf("ping failed: %v", err)
-            continue
-        }
-
-        return db, nil 
-    }
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("error to connect to db: %w", ctx.Err())
+		}
+		db, err := sql.Open(PostgresDriver, psqlInfo)
+		if err != nil {
+			logrus.Errorf("failed to open database connection: %v", err)
+			continue
+		}
+
+		err = db.PingContext(ctx)
+		if err != nil {
+			db.Close()
+			logrus.Errorf("ping failed: %v", err)
+			continue
+		}
+
+		return db, nil
+	}
 	return nil, fmt.Errorf("error to connect to db")
 }
\ No newline at end of file
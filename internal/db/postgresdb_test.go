@@ -2,165 +2,118 @@ package db_test
 
 import (
 	"context"
+	"log"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"postsandcomments/internal/graph/model"
 	"postsandcomments/internal/db"
+	"postsandcomments/internal/db/dbtest"
 
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
-func setupTestDB(t *testing.T) *db.PostgresDB {
-	db, err := db.NewPostgresDB("db", 5432, "postgres", "password")
-	if err != nil {
-		t.Fatalf("Failed to connect to test database: %v", err)
+// Postgres tests run against the server from POSTGRES_TEST_HOST (the db service of
+// test_docker-compose.yml) or, with POSTGRES_TEST_EMBEDDED=true, against an embedded
+// Postgres started by TestMain. Without either of them they are skipped.
+func TestMain(m *testing.M) {
+	if os.Getenv("POSTGRES_TEST_EMBEDDED") != "true" {
+		os.Exit(m.Run())
 	}
-	return db
-}
-
-func TestNewPostgresDB(t *testing.T) {
-	db, err := db.NewPostgresDB("db", 5432, "postgres", "password")
-	assert.NoError(t, err)
-	assert.NotNil(t, db)
-}
-
-func TestCreatePostPostgres(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.DB.Close()
 
-	post := &model.Post{
-		ID:            uuid.New().String(),
-		Title:         "Test Post",
-		Body:          "Test body",
-		AllowComments: true,
+	postgres := embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().
+		Port(embeddedPostgresPort).
+		Username("postgres").
+		Password("password").
+		RuntimePath(filepath.Join(os.TempDir(), "postsandcomments-embedded-postgres")))
+	if err := postgres.Start(); err != nil {
+		log.Fatalf("Failed to start embedded postgres: %v", err)
 	}
 
-	err := db.CreatePost(context.Background(), post)
-	assert.NoError(t, err)
-
-	posts, err := db.GetPosts(context.Background())
-	assert.NoError(t, err)
-	assert.Equal(t, 1, len(posts))
-	assert.Equal(t, post, posts[0])
+	code := m.Run()
+	if err := postgres.Stop(); err != nil {
+		log.Printf("Failed to stop embedded postgres: %v", err)
+	}
+	os.Exit(code)
 }
 
-func TestGetPostsPostgres(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.DB.Close()
+const embeddedPostgresPort = 55432
 
-	post1 := &model.Post{
-		ID:            uuid.New().String(),
-		Title:         "Post 1",
-		Body:          "Body 1",
-		AllowComments: true,
+func setupTestDB(t *testing.T) *db.PostgresDB {
+	host, port := os.Getenv("POSTGRES_TEST_HOST"), 5432
+	if os.Getenv("POSTGRES_TEST_EMBEDDED") == "true" {
+		host, port = "localhost", embeddedPostgresPort
 	}
-	post2 := &model.Post{
-		ID:            uuid.New().String(),
-		Title:         "Post 2",
-		Body:          "Body 2",
-		AllowComments: false,
+	if host == "" {
+		t.Skip("set POSTGRES_TEST_HOST or POSTGRES_TEST_EMBEDDED=true to run Postgres tests")
 	}
 
-	err := db.CreatePost(context.Background(), post1)
-	assert.NoError(t, err)
-	err = db.CreatePost(context.Background(), post2)
-	assert.NoError(t, err)
-
-	posts, err := db.GetPosts(context.Background())
-	assert.NoError(t, err)
-	assert.Equal(t, 2, len(posts))
-	assert.Equal(t, posts[0], post1)
-	assert.Equal(t, posts[1], post2)
-
+	db, err := db.NewPostgresDB(context.Background(), host, port, "postgres", "password")
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	cleanTestDB(t, db)
+	return db
 }
 
-func TestGetPostByIdPostgres(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.DB.Close()
-
-	post := &model.Post{
-		ID:            uuid.New().String(),
-		Title:         "Test Post",
-		Body:          "Test body",
-		AllowComments: true,
+func cleanTestDB(t *testing.T, db *db.PostgresDB) {
+	_, err := db.DB.Exec("TRUNCATE comments, posts, users, webhooks, webhook_dead_letters")
+	if err != nil {
+		t.Fatalf("Failed to clean test database: %v", err)
 	}
-
-	err := db.CreatePost(context.Background(), post)
-	assert.NoError(t, err)
-
-	fetchedPost, err := db.GetPostById(context.Background(), post.ID, nil, nil)
-	assert.NoError(t, err)
-	assert.Equal(t, post, fetchedPost)
 }
 
-func TestGetCommentByIdPostgres(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.DB.Close()
+func TestNewPostgresDB(t *testing.T) {
+	postgres := setupTestDB(t)
+	defer postgres.DB.Close()
 
+	assert.NotNil(t, postgres)
+}
 
-	post := &model.Post{
-		ID:            uuid.New().String(),
-		Title:         "Test Post",
-		Body:          "Test body",
-		AllowComments: true,
-	}
-	comment := &model.Comment{
-		ID:       uuid.New().String(),
-		PostID:   post.ID,
-		Body:     "Test Comment",
-		ParentID: nil,
-	}
-	err := db.CreatePost(context.Background(), post)
-	assert.NoError(t, err)
-	err = db.CreateComment(context.Background(), post, comment)
-	assert.NoError(t, err)
+func TestPostgresConformance(t *testing.T) {
+	postgres := setupTestDB(t)
+	defer postgres.DB.Close()
 
-	retrievedComment, err := db.GetCommentById(context.Background(), comment.ID)
-	assert.NoError(t, err)
-	assert.Equal(t, comment, retrievedComment)
+	dbtest.RunConformance(t, func() db.Database {
+		cleanTestDB(t, postgres)
+		return postgres
+	})
 }
 
-func TestCreateCommentPostgres(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.DB.Close()
+func TestPostgresMigrationsDownAndUp(t *testing.T) {
+	postgres := setupTestDB(t)
+	defer postgres.DB.Close()
 
-	post := &model.Post{
-		ID:            uuid.New().String(),
-		Title:         "Test Post",
-		Body:          "Test body",
-		AllowComments: true,
-	}
+	migrator, err := db.NewPostgresMigrator(postgres.DB)
+	assert.NoError(t, err)
 
-	err := db.CreatePost(context.Background(), post)
+	reverted, err := migrator.Down(context.Background(), len(migrator.Migrations))
 	assert.NoError(t, err)
+	assert.Len(t, reverted, len(migrator.Migrations))
 
-	comment := &model.Comment{
-		ID:       uuid.New().String(),
-		PostID:   post.ID,
-		Body:     "Test Comment",
-		ParentID: nil,
+	statuses, err := migrator.Status(context.Background())
+	assert.NoError(t, err)
+	for _, status := range statuses {
+		assert.Nil(t, status.AppliedAt)
 	}
 
-	err = db.CreateComment(context.Background(), post, comment)
+	applied, err := migrator.Up(context.Background())
 	assert.NoError(t, err)
+	assert.Len(t, applied, len(migrator.Migrations))
 
-	childrenComment := &model.Comment{
-		ID:       uuid.New().String(),
-		PostID:   post.ID,
-		Body:     "Test Comment",
-		ParentID: &comment.ID,
-	}
-	db.CreateComment(context.Background(), post, childrenComment)
-	post, err = db.GetPostById(context.Background(), post.ID, nil, nil)
+	applied, err = migrator.Up(context.Background())
 	assert.NoError(t, err)
-	assert.Equal(t, childrenComment, post.Comments[1])
+	assert.Empty(t, applied)
 }
 
-func TestCreateCommentWithLimitAndOffsetPostgres(t *testing.T) {
+func TestGetCommentsWithChildrenPostgres(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.DB.Close()
+	db.MaxDepth = 2
 
 	post := &model.Post{
 		ID:            uuid.New().String(),
@@ -168,44 +121,36 @@ func TestCreateCommentWithLimitAndOffsetPostgres(t *testing.T) {
 		Body:          "Test body",
 		AllowComments: true,
 	}
-
 	err := db.CreatePost(context.Background(), post)
 	assert.NoError(t, err)
 
-	comments := []*model.Comment{
-		{
-			ID:       uuid.New().String(),
-			PostID:   post.ID,
-			Body:     "Test Comment 1",
-			ParentID: nil,
-		},
-		{
+	var parentID *string
+	thread := make([]*model.Comment, 0)
+	for i := 0; i < 4; i++ {
+		comment := &model.Comment{
 			ID:       uuid.New().String(),
 			PostID:   post.ID,
-			Body:     "Test Comment 2",
-			ParentID: nil,
-		},
-	}
-	childComment := &model.Comment{
-		ID:       uuid.New().String(),
-		PostID:   post.ID,
-		Body:     "Test Comment 1-1",
-		ParentID: &comments[0].ID,
-	}
-	comments = append(comments, childComment)
-
-	for _, comment := range comments {
-		err = db.CreateComment(context.Background(), post, comment)
+			Body:     "Test Comment",
+			ParentID: parentID,
+		}
+		err := db.CreateComment(context.Background(), post, comment)
 		assert.NoError(t, err)
+		thread = append(thread, comment)
+		parentID = &comment.ID
 	}
 
-	limit, offset := 1, 1
-	fetchedPost, err := db.GetPostById(context.Background(), post.ID, &limit, &offset)
-
+	comments, err := db.GetComments(context.Background(), post.ID, nil, 10, nil)
 	assert.NoError(t, err)
-	assert.Equal(t, comments[1], fetchedPost.Comments[0])
-	limit, offset = 1, 2
-	fetchedPost, err = db.GetPostById(context.Background(), post.ID, &limit, &offset)
+	assert.Len(t, comments, 1)
+	assert.Len(t, comments[0].Children, 1)
+	assert.Equal(t, thread[1].ID, comments[0].Children[0].ID)
+	assert.Len(t, comments[0].Children[0].Children, 1)
+	assert.Equal(t, thread[2].ID, comments[0].Children[0].Children[0].ID)
+	assert.Empty(t, comments[0].Children[0].Children[0].Children)
+
+	comment, err := db.GetCommentById(context.Background(), thread[1].ID)
 	assert.NoError(t, err)
-	assert.Equal(t, comments[2], fetchedPost.Comments[0])
+	assert.Len(t, comment.Children, 1)
+	assert.Len(t, comment.Children[0].Children, 1)
+	assert.Equal(t, thread[3].ID, comment.Children[0].Children[0].ID)
 }
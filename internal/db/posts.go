@@ -0,0 +1,103 @@
+package db
+
+import (
+	"fmt"
+	"postsandcomments/internal/graph/model"
+	"strings"
+	"time"
+)
+
+// postSortKey is the sort key of posts in the order, with the columns it is
+// stored in. Posts are sorted by the key descending, or ascending for OLDEST.
+func postSortKey(order model.PostOrder, post *model.Post) (columns []string, values []interface{}) {
+	switch order {
+	case model.PostOrderMostCommented:
+		return []string{"comment_count", "created_at", "id"}, []interface{}{post.CommentCount, post.CreatedAt, post.ID}
+	case model.PostOrderRecentActivity:
+		return []string{"last_activity_at", "created_at", "id"}, []interface{}{post.LastActivityAt, post.CreatedAt, post.ID}
+	default:
+		return []string{"created_at", "id"}, []interface{}{post.CreatedAt, post.ID}
+	}
+}
+
+// postBefore reports whether a comes before b in the order.
+func postBefore(order model.PostOrder, a *model.Post, b *model.Post) bool {
+	_, aKey := postSortKey(order, a)
+	_, bKey := postSortKey(order, b)
+	for i := range aKey {
+		if c := compareKeyValues(aKey[i], bKey[i]); c != 0 {
+			if order == model.PostOrderOldest {
+				return c < 0
+			}
+			return c > 0
+		}
+	}
+	return false
+}
+
+func compareKeyValues(a interface{}, b interface{}) int {
+	switch a := a.(type) {
+	case int:
+		return a - b.(int)
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	default:
+		return strings.Compare(a.(string), b.(string))
+	}
+}
+
+// matches reports whether the post passes the filters of the query.
+func (q PostQuery) matches(post *model.Post) bool {
+	return (q.AuthorID == nil || post.AuthorID != nil && *post.AuthorID == *q.AuthorID) &&
+		(q.AllowComments == nil || post.AllowComments == *q.AllowComments) &&
+		(q.CreatedAfter == nil || post.CreatedAt.After(*q.CreatedAfter))
+}
+
+// postsSQL builds the query of GetPosts for the SQL backends. Both of them take
+// $n placeholders and compare row values, so the page after the cursor is a
+// range of the index of the order.
+func postsSQL(query PostQuery) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		if t, ok := value.(time.Time); ok {
+			// SQLite compares timestamps as text, which only works in one zone.
+			value = t.UTC()
+		}
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if query.AuthorID != nil {
+		conditions = append(conditions, "author_id = "+arg(*query.AuthorID))
+	}
+	if query.AllowComments != nil {
+		conditions = append(conditions, "allow_comments = "+arg(*query.AllowComments))
+	}
+	if query.CreatedAfter != nil {
+		conditions = append(conditions, "created_at > "+arg(*query.CreatedAfter))
+	}
+
+	columns, _ := postSortKey(query.OrderBy, &model.Post{})
+	direction, operator := " DESC", "<"
+	if query.OrderBy == model.PostOrderOldest {
+		direction, operator = "", ">"
+	}
+	if query.After != nil {
+		_, values := postSortKey(query.OrderBy, query.After)
+		placeholders := make([]string, len(values))
+		for i, value := range values {
+			placeholders[i] = arg(value)
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), operator, strings.Join(placeholders, ", ")))
+	}
+
+	statement := "SELECT id, title, body, allow_comments, author_id, created_at, updated_at, comment_count, last_activity_at FROM posts"
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	statement += " ORDER BY " + strings.Join(columns, direction+", ") + direction
+	statement += " LIMIT " + arg(query.First)
+
+	return statement, args
+}
@@ -0,0 +1,593 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"postsandcomments/internal/graph/model"
+
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteDB stores posts and comments in a single SQLite file. It is meant for
+// small deployments where one process owns the file.
+type SQLiteDB struct {
+	DB       *sql.DB
+	MaxDepth int
+}
+
+func NewSQLiteDB(path string) (*SQLiteDB, error) {
+	db, err := OpenSQLite(path)
+	if err != nil {
+		return nil, fmt.Errorf("error to create sqlite db: %v", err)
+	}
+
+	migrator, err := NewSQLiteMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error to load migrations: %v", err)
+	}
+
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error to migrate sqlite db: %v", err)
+	}
+	for _, migration := range applied {
+		logrus.Infof("applied migration %d_%s", migration.Version, migration.Name)
+	}
+
+	return &SQLiteDB{DB: db}, nil
+}
+
+// OpenSQLite opens the file in WAL mode, so readers don't wait for the writer.
+// Writers still go one at a time: transactions take the write lock when they
+// begin and wait up to busy_timeout for it.
+func OpenSQLite(path string) (*sql.DB, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("error to create directory for sqlite db: %v", err)
+		}
+	}
+
+	params := url.Values{}
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "synchronous(NORMAL)")
+	params.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (db *SQLiteDB) CreatePost(ctx context.Context, post *model.Post) error {
+	query := `
+		INSERT INTO posts (id, title, body, allow_comments, author_id, created_at, updated_at, last_activity_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $6)
+	`
+	_, err := db.DB.ExecContext(ctx, query, post.ID, post.Title, post.Body, post.AllowComments, post.AuthorID, post.CreatedAt.UTC(), post.UpdatedAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	post.CommentCount = 0
+	post.LastActivityAt = post.CreatedAt
+	return nil
+}
+
+func (db *SQLiteDB) GetPosts(ctx context.Context, query PostQuery) ([]*model.Post, error) {
+	statement, args := postsSQL(query)
+	rows, err := db.DB.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]*model.Post, 0, query.First)
+	for rows.Next() {
+		var post model.Post
+		err := rows.Scan(
+			&post.ID,
+			&post.Title,
+			&post.Body,
+			&post.AllowComments,
+			&post.AuthorID,
+			inUTC(&post.CreatedAt),
+			inUTC(&post.UpdatedAt),
+			&post.CommentCount,
+			inUTC(&post.LastActivityAt),
+		)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, &post)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+func (db *SQLiteDB) GetPostById(ctx context.Context, id string) (*model.Post, error) {
+	query := "SELECT id, title, body, allow_comments, author_id, created_at, updated_at, comment_count, last_activity_at FROM posts WHERE id=$1"
+	row := db.DB.QueryRowContext(ctx, query, id)
+	var post model.Post
+
+	err := row.Scan(
+		&post.ID,
+		&post.Title,
+		&post.Body,
+		&post.AllowComments,
+		&post.AuthorID,
+		inUTC(&post.CreatedAt),
+		inUTC(&post.UpdatedAt),
+		&post.CommentCount,
+		inUTC(&post.LastActivityAt),
+	)
+	if err == sql.ErrNoRows {
+		return nil, notFound("posts", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &post, nil
+}
+
+func (db *SQLiteDB) UpdatePost(ctx context.Context, post *model.Post) error {
+	query := `UPDATE posts SET title = $2, body = $3, allow_comments = $4, updated_at = $5 WHERE id = $1`
+	result, err := db.DB.ExecContext(ctx, query, post.ID, post.Title, post.Body, post.AllowComments, post.UpdatedAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result, "posts", post.ID)
+}
+
+func (db *SQLiteDB) DeletePost(ctx context.Context, id string) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM comments WHERE post_id = $1", id); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM posts WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if err := expectAffected(result, "posts", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) GetCommentById(ctx context.Context, id string) (*model.Comment, error) {
+	row := db.DB.QueryRowContext(ctx, "SELECT id, post_id, body, parent_id, author_id, deleted, seq, created_at, updated_at FROM comments WHERE id=$1", id)
+	var comment model.Comment
+
+	err := row.Scan(
+		&comment.ID,
+		&comment.PostID,
+		&comment.Body,
+		&comment.ParentID,
+		&comment.AuthorID,
+		&comment.Deleted,
+		&comment.Seq,
+		inUTC(&comment.CreatedAt),
+		inUTC(&comment.UpdatedAt),
+	)
+	if err == sql.ErrNoRows {
+		return nil, notFound("comments", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.fillChildren(ctx, []*model.Comment{&comment})
+	if err != nil {
+		return nil, fmt.Errorf("error to get replies: %v", err)
+	}
+
+	return &comment, nil
+}
+
+func (db *SQLiteDB) CreateComment(ctx context.Context, post *model.Post, comment *model.Comment) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The post may have been deleted since the caller read it.
+	query := `UPDATE posts SET comment_count = comment_count + 1, last_activity_at = MAX(last_activity_at, $2) WHERE id = $1`
+	result, err := tx.ExecContext(ctx, query, post.ID, comment.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	if err := expectAffected(result, "posts", post.ID); err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO comments (id, post_id, body, parent_id, author_id, created_at, updated_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7
+		WHERE $4 IS NULL OR EXISTS (SELECT 1 FROM comments WHERE id = $4 AND post_id = $2)
+		RETURNING seq
+	`
+	row := tx.QueryRowContext(ctx, query, comment.ID, post.ID, comment.Body, comment.ParentID, comment.AuthorID, comment.CreatedAt.UTC(), comment.UpdatedAt.UTC())
+	err = row.Scan(&comment.Seq)
+	if err == sql.ErrNoRows {
+		// The parent is missing or belongs to another post.
+		var exists bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM comments WHERE id = $1)", *comment.ParentID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("%w: %s", ErrParentPostMismatch, *comment.ParentID)
+		}
+		return notFound("comments", *comment.ParentID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) UpdateComment(ctx context.Context, comment *model.Comment) error {
+	result, err := db.DB.ExecContext(ctx, "UPDATE comments SET body = $2, updated_at = $3 WHERE id = $1", comment.ID, comment.Body, comment.UpdatedAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result, "comments", comment.ID)
+}
+
+// DeleteComment needs no row lock: the transaction begins immediate, so it
+// holds the database write lock and no reply can be added until it ends.
+func (db *SQLiteDB) DeleteComment(ctx context.Context, id string) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var postID string
+	var parentID *string
+	var deleted, hasReplies bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT c.post_id, c.parent_id, c.deleted, EXISTS (SELECT 1 FROM comments WHERE parent_id = c.id)
+		FROM comments c
+		WHERE c.id = $1
+	`, id).Scan(&postID, &parentID, &deleted, &hasReplies)
+	if err == sql.ErrNoRows {
+		return notFound("comments", id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if hasReplies {
+		query := `UPDATE comments SET body = $2, deleted = TRUE, author_id = NULL WHERE id = $1`
+		_, err = tx.ExecContext(ctx, query, id, DeletedCommentBody)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM comments WHERE id = $1", id)
+		if err == nil {
+			err = deleteEmptyTombstones(ctx, tx, parentID, "")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// A tombstone is not counted already.
+	if !deleted {
+		_, err = tx.ExecContext(ctx, "UPDATE posts SET comment_count = comment_count - 1 WHERE id = $1", postID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) GetComments(ctx context.Context, postId string, parentId *string, first int, after *int64) ([]*model.Comment, error) {
+	var afterSeq int64
+	if after != nil {
+		afterSeq = *after
+	}
+
+	query := `
+		SELECT id, post_id, body, parent_id, author_id, deleted, seq, created_at, updated_at
+		FROM comments
+		WHERE post_id = $1 AND parent_id IS NULL AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`
+	args := []interface{}{postId, afterSeq, first}
+	if parentId != nil {
+		query = `
+			SELECT id, post_id, body, parent_id, author_id, deleted, seq, created_at, updated_at
+			FROM comments
+			WHERE post_id = $1 AND parent_id = $4 AND seq > $2
+			ORDER BY seq
+			LIMIT $3
+		`
+		args = append(args, *parentId)
+	}
+
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := make([]*model.Comment, 0, first)
+	for rows.Next() {
+		var comment model.Comment
+		err := rows.Scan(
+			&comment.ID,
+			&comment.PostID,
+			&comment.Body,
+			&comment.ParentID,
+			&comment.AuthorID,
+			&comment.Deleted,
+			&comment.Seq,
+			inUTC(&comment.CreatedAt),
+			inUTC(&comment.UpdatedAt),
+		)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, &comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = db.fillChildren(ctx, comments)
+	if err != nil {
+		return nil, fmt.Errorf("error to get replies: %v", err)
+	}
+
+	return comments, nil
+}
+
+func (db *SQLiteDB) GetCommentsSince(ctx context.Context, postId string, afterSeq int64, limit int) ([]*model.Comment, error) {
+	query := `
+		SELECT id, post_id, body, parent_id, author_id, deleted, seq, created_at, updated_at
+		FROM comments
+		WHERE post_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`
+	rows, err := db.DB.QueryContext(ctx, query, postId, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := make([]*model.Comment, 0)
+	for rows.Next() {
+		var comment model.Comment
+		err := rows.Scan(
+			&comment.ID,
+			&comment.PostID,
+			&comment.Body,
+			&comment.ParentID,
+			&comment.AuthorID,
+			&comment.Deleted,
+			&comment.Seq,
+			inUTC(&comment.CreatedAt),
+			inUTC(&comment.UpdatedAt),
+		)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, &comment)
+	}
+
+	return comments, rows.Err()
+}
+
+// fillChildren loads replies to the comments into their Children, at most MaxDepth levels deep.
+// SQLite has no arrays, so the ids are passed as a JSON array and expanded with json_each.
+func (db *SQLiteDB) fillChildren(ctx context.Context, comments []*model.Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(comments))
+	byId := make(map[string]*model.Comment, len(comments))
+	for _, comment := range comments {
+		ids = append(ids, comment.ID)
+		byId[comment.ID] = comment
+	}
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		WITH RECURSIVE comment_tree AS (
+			SELECT id, post_id, body, parent_id, author_id, deleted, seq, created_at, updated_at, 1 AS depth
+			FROM comments
+			WHERE parent_id IN (SELECT value FROM json_each($1))
+
+			UNION ALL
+
+			SELECT c.id, c.post_id, c.body, c.parent_id, c.author_id, c.deleted, c.seq, c.created_at, c.updated_at, ct.depth + 1
+			FROM comments c
+			INNER JOIN comment_tree ct ON c.parent_id = ct.id
+			WHERE ct.depth < $2
+		)
+		SELECT id, post_id, body, parent_id, author_id, deleted, seq, created_at, updated_at FROM comment_tree ORDER BY seq
+	`
+	rows, err := db.DB.QueryContext(ctx, query, string(idsJSON), maxDepth(db.MaxDepth))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Replies are always created after their parents, so ordering by seq
+	// puts every parent before its children.
+	for rows.Next() {
+		var comment model.Comment
+		err := rows.Scan(
+			&comment.ID,
+			&comment.PostID,
+			&comment.Body,
+			&comment.ParentID,
+			&comment.AuthorID,
+			&comment.Deleted,
+			&comment.Seq,
+			inUTC(&comment.CreatedAt),
+			inUTC(&comment.UpdatedAt),
+		)
+		if err != nil {
+			return err
+		}
+
+		parent, exists := byId[*comment.ParentID]
+		if !exists {
+			continue
+		}
+		parent.Children = append(parent.Children, &comment)
+		byId[comment.ID] = &comment
+	}
+
+	return rows.Err()
+}
+
+func (db *SQLiteDB) SaveUser(ctx context.Context, user *model.User) error {
+	query := `INSERT INTO users (id, name) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET name = excluded.name`
+	_, err := db.DB.ExecContext(ctx, query, user.ID, user.Name)
+	return err
+}
+
+func (db *SQLiteDB) GetUserById(ctx context.Context, id string) (*model.User, error) {
+	row := db.DB.QueryRowContext(ctx, "SELECT id, name FROM users WHERE id=$1", id)
+	var user model.User
+
+	err := row.Scan(&user.ID, &user.Name)
+	if err == sql.ErrNoRows {
+		return nil, notFound("users", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (db *SQLiteDB) GetUsersByIds(ctx context.Context, ids []string) ([]*model.User, error) {
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.DB.QueryContext(ctx, "SELECT id, name FROM users WHERE id IN (SELECT value FROM json_each($1))", string(idsJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanUsers(rows)
+}
+
+func (db *SQLiteDB) Ping(ctx context.Context) error {
+	return db.DB.PingContext(ctx)
+}
+
+func (db *SQLiteDB) Close() error {
+	return db.DB.Close()
+}
+
+func (db *SQLiteDB) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	events, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO webhooks (id, url, events, post_id, secret) VALUES ($1, $2, $3, $4, $5)`
+	_, err = db.DB.ExecContext(ctx, query, webhook.ID, webhook.URL, string(events), webhook.PostID, webhook.Secret)
+	return err
+}
+
+func (db *SQLiteDB) GetWebhooks(ctx context.Context) ([]*model.Webhook, error) {
+	rows, err := db.DB.QueryContext(ctx, "SELECT id, url, events, post_id, secret FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]*model.Webhook, 0)
+	for rows.Next() {
+		var webhook model.Webhook
+		var events string
+		if err := rows.Scan(&webhook.ID, &webhook.URL, &events, &webhook.PostID, &webhook.Secret); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(events), &webhook.Events); err != nil {
+			return nil, fmt.Errorf("invalid events of webhook %s: %v", webhook.ID, err)
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (db *SQLiteDB) DeleteWebhook(ctx context.Context, id string) error {
+	result, err := db.DB.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result, "webhooks", id)
+}
+
+func (db *SQLiteDB) CreateDeadLetter(ctx context.Context, delivery *model.WebhookDelivery) error {
+	query := `INSERT INTO webhook_dead_letters (id, webhook_id, event, payload, attempts, last_error, failed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := db.DB.ExecContext(ctx, query, delivery.ID, delivery.WebhookID, string(delivery.Event), delivery.Payload, delivery.Attempts, delivery.LastError, delivery.FailedAt.UTC())
+	return err
+}
+
+func (db *SQLiteDB) GetDeadLetters(ctx context.Context, webhookID *string, limit int) ([]*model.WebhookDelivery, error) {
+	query := `SELECT id, webhook_id, event, payload, attempts, last_error, failed_at FROM webhook_dead_letters
+		WHERE $1 IS NULL OR webhook_id = $1
+		ORDER BY failed_at DESC
+		LIMIT $2`
+	rows, err := db.DB.QueryContext(ctx, query, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDeadLetters(rows)
+}
@@ -0,0 +1,59 @@
+package db_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/db/dbtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupSQLiteDB(t *testing.T) *db.SQLiteDB {
+	sqlite, err := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open sqlite database: %v", err)
+	}
+	t.Cleanup(func() { sqlite.DB.Close() })
+	return sqlite
+}
+
+func TestNewSQLiteDB(t *testing.T) {
+	sqlite := setupSQLiteDB(t)
+
+	var journalMode string
+	err := sqlite.DB.QueryRow("PRAGMA journal_mode").Scan(&journalMode)
+	assert.NoError(t, err)
+	assert.Equal(t, "wal", journalMode)
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	dbtest.RunConformance(t, func() db.Database {
+		return setupSQLiteDB(t)
+	})
+}
+
+func TestSQLiteMigrationsDownAndUp(t *testing.T) {
+	sqlite := setupSQLiteDB(t)
+	ctx := context.Background()
+
+	migrator, err := db.NewSQLiteMigrator(sqlite.DB)
+	require.NoError(t, err)
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.NotNil(t, status.AppliedAt, "migration %d_%s", status.Version, status.Name)
+	}
+
+	reverted, err := migrator.Down(ctx, len(migrator.Migrations))
+	require.NoError(t, err)
+	assert.Len(t, reverted, len(migrator.Migrations))
+
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(migrator.Migrations))
+}
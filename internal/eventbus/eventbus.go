@@ -0,0 +1,74 @@
+package eventbus
+
+import (
+	"context"
+	"sync"
+
+	"postsandcomments/internal/graph/model"
+)
+
+type EventType string
+
+const (
+	PostAdded      EventType = "postAdded"
+	CommentAdded   EventType = "commentAdded"
+	CommentUpdated EventType = "commentUpdated"
+	CommentDeleted EventType = "commentDeleted"
+)
+
+// Event is a change of a post or a comment. Post is set for PostAdded, Comment
+// for comment events. A CommentDeleted event carries only the ids of the comment,
+// since the comment may no longer exist.
+type Event struct {
+	Type    EventType
+	PostID  string
+	Post    *model.Post
+	Comment *model.Comment
+}
+
+type Handler func(event *Event)
+
+// Bus carries events to the handlers of every instance of the service.
+// Handlers must not block, they are called from the goroutine delivering events.
+type Bus interface {
+	Publish(ctx context.Context, event *Event) error
+	Subscribe(handler Handler)
+	// Ping reports whether events from other instances are received.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// LocalBus delivers events within the process, it is enough for a single instance.
+type LocalBus struct {
+	mutex    sync.RWMutex
+	handlers []Handler
+}
+
+func NewLocalBus() *LocalBus {
+	return &LocalBus{}
+}
+
+func (b *LocalBus) Publish(ctx context.Context, event *Event) error {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, handler := range b.handlers {
+		handler(event)
+	}
+	return nil
+}
+
+func (b *LocalBus) Subscribe(handler Handler) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *LocalBus) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (b *LocalBus) Close() error {
+	return nil
+}
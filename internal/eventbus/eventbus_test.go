@@ -0,0 +1,95 @@
+package eventbus_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/eventbus"
+	"postsandcomments/internal/graph/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalBus(t *testing.T) {
+	bus := eventbus.NewLocalBus()
+
+	var first, second []*eventbus.Event
+	bus.Subscribe(func(event *eventbus.Event) { first = append(first, event) })
+	bus.Subscribe(func(event *eventbus.Event) { second = append(second, event) })
+
+	event := &eventbus.Event{Type: eventbus.CommentAdded, PostID: "test_post_id", Comment: &model.Comment{ID: "test_comment_id"}}
+	require.NoError(t, bus.Publish(context.Background(), event))
+
+	assert.Equal(t, []*eventbus.Event{event}, first)
+	assert.Equal(t, []*eventbus.Event{event}, second)
+	assert.NoError(t, bus.Close())
+}
+
+// TestPostgresBus runs two buses on one database, like two replicas of the service.
+// It needs the same POSTGRES_TEST_HOST as the Postgres tests of the db package.
+func TestPostgresBus(t *testing.T) {
+	host := os.Getenv("POSTGRES_TEST_HOST")
+	if host == "" {
+		t.Skip("set POSTGRES_TEST_HOST to run Postgres tests")
+	}
+	connInfo := db.PostgresConnInfo(host, 5432, "postgres", "password")
+
+	postgres, err := db.NewPostgresDB(context.Background(), host, 5432, "postgres", "password")
+	require.NoError(t, err)
+	defer postgres.DB.Close()
+
+	ctx := context.Background()
+	post := &model.Post{ID: uuid.New().String(), Title: "Test Post", Body: "Test body", AllowComments: true}
+	require.NoError(t, postgres.CreatePost(ctx, post))
+	comment := &model.Comment{ID: uuid.New().String(), PostID: post.ID, Body: "Test Comment"}
+	require.NoError(t, postgres.CreateComment(ctx, post, comment))
+
+	publisher, err := eventbus.NewPostgresBus(connInfo, postgres.DB, postgres)
+	require.NoError(t, err)
+	defer publisher.Close()
+	receiver, err := eventbus.NewPostgresBus(connInfo, postgres.DB, postgres)
+	require.NoError(t, err)
+	defer receiver.Close()
+
+	published := make(chan *eventbus.Event, 10)
+	publisher.Subscribe(func(event *eventbus.Event) { published <- event })
+	received := make(chan *eventbus.Event, 10)
+	receiver.Subscribe(func(event *eventbus.Event) { received <- event })
+
+	events := []*eventbus.Event{
+		{Type: eventbus.PostAdded, PostID: post.ID, Post: post},
+		{Type: eventbus.CommentAdded, PostID: post.ID, Comment: comment},
+		{Type: eventbus.CommentDeleted, PostID: post.ID, Comment: &model.Comment{ID: uuid.New().String(), PostID: post.ID}},
+	}
+	for _, event := range events {
+		require.NoError(t, publisher.Publish(ctx, event))
+	}
+
+	for _, expected := range events {
+		select {
+		case event := <-received:
+			assert.Equal(t, expected.Type, event.Type)
+			assert.Equal(t, post.ID, event.PostID)
+			if expected.Post != nil {
+				assert.Equal(t, post.Title, event.Post.Title)
+			}
+			if expected.Comment != nil {
+				assert.Equal(t, expected.Comment.ID, event.Comment.ID)
+			}
+			if expected.Type == eventbus.CommentAdded {
+				assert.Equal(t, comment.Body, event.Comment.Body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s event is not delivered to another instance", expected.Type)
+		}
+	}
+
+	// The publishing instance gets its events once, not again from the notifications.
+	time.Sleep(200 * time.Millisecond)
+	assert.Len(t, published, len(events))
+}
@@ -0,0 +1,189 @@
+package eventbus
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"postsandcomments/internal/graph/model"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// PostgresChannel is the LISTEN/NOTIFY channel shared by all instances.
+const PostgresChannel = "comment_events"
+
+const (
+	listenerMinReconnect = 10 * time.Second
+	listenerMaxReconnect = time.Minute
+	listenerPingInterval = 90 * time.Second
+	loadTimeout          = 5 * time.Second
+)
+
+// Loader reads posts and comments from the shared database, db.Database implements it.
+type Loader interface {
+	GetPostById(ctx context.Context, id string) (*model.Post, error)
+	GetCommentById(ctx context.Context, id string) (*model.Comment, error)
+}
+
+// PostgresBus fans events out to every instance connected to the same database
+// with LISTEN/NOTIFY. A notification payload is limited to 8000 bytes, which a
+// comment body alone can exceed, so only ids are sent and other instances load
+// posts and comments with Loader. The publishing instance delivers its own
+// events right away and skips their notifications.
+type PostgresBus struct {
+	db       *sql.DB
+	listener *pq.Listener
+	loader   Loader
+	instance string
+	local    *LocalBus
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+type notification struct {
+	Instance  string    `json:"instance"`
+	Type      EventType `json:"type"`
+	PostID    string    `json:"postId"`
+	CommentID string    `json:"commentId,omitempty"`
+	ParentID  *string   `json:"parentId,omitempty"`
+}
+
+// NewPostgresBus listens with its own connection opened from connInfo and
+// notifies through db.
+func NewPostgresBus(connInfo string, db *sql.DB, loader Loader) (*PostgresBus, error) {
+	listener := pq.NewListener(connInfo, listenerMinReconnect, listenerMaxReconnect, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logrus.Errorf("postgres event bus listener: %v", err)
+		}
+	})
+	if err := listener.Listen(PostgresChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error to listen to %s: %v", PostgresChannel, err)
+	}
+
+	bus := &PostgresBus{
+		db:       db,
+		listener: listener,
+		loader:   loader,
+		instance: uuid.New().String(),
+		local:    NewLocalBus(),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go bus.listen()
+
+	return bus, nil
+}
+
+func (b *PostgresBus) Publish(ctx context.Context, event *Event) error {
+	n := notification{
+		Instance: b.instance,
+		Type:     event.Type,
+		PostID:   event.PostID,
+	}
+	if event.Comment != nil {
+		n.CommentID = event.Comment.ID
+		n.ParentID = event.Comment.ParentID
+	}
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	b.local.Publish(ctx, event)
+
+	if _, err := b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", PostgresChannel, string(payload)); err != nil {
+		return fmt.Errorf("error to notify other instances: %v", err)
+	}
+	return nil
+}
+
+func (b *PostgresBus) Subscribe(handler Handler) {
+	b.local.Subscribe(handler)
+}
+
+// Ping checks the listening connection, notifications sent while it is down are lost.
+func (b *PostgresBus) Ping(ctx context.Context) error {
+	if err := b.listener.Ping(); err != nil {
+		return fmt.Errorf("listener is disconnected: %v", err)
+	}
+	return nil
+}
+
+func (b *PostgresBus) Close() error {
+	close(b.done)
+	<-b.stopped
+	return b.listener.Close()
+}
+
+func (b *PostgresBus) listen() {
+	defer close(b.stopped)
+
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case n := <-b.listener.Notify:
+			// nil is sent after the connection is restored, notifications
+			// sent while it was lost are gone.
+			if n == nil {
+				logrus.Warnf("postgres event bus reconnected, events from other instances may be lost")
+				continue
+			}
+			b.receive(n.Extra)
+		case <-ticker.C:
+			go b.listener.Ping()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *PostgresBus) receive(payload string) {
+	var n notification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		logrus.Errorf("invalid event bus notification %q: %v", payload, err)
+		return
+	}
+	if n.Instance == b.instance {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+
+	event, err := b.load(ctx, n)
+	if err != nil {
+		logrus.Errorf("error to load %s event for post %s from event bus: %v", n.Type, n.PostID, err)
+		return
+	}
+
+	b.local.Publish(ctx, event)
+}
+
+func (b *PostgresBus) load(ctx context.Context, n notification) (*Event, error) {
+	event := &Event{Type: n.Type, PostID: n.PostID}
+
+	var err error
+	switch n.Type {
+	case PostAdded:
+		event.Post, err = b.loader.GetPostById(ctx, n.PostID)
+	case CommentAdded, CommentUpdated:
+		event.Comment, err = b.loader.GetCommentById(ctx, n.CommentID)
+	case CommentDeleted:
+		event.Comment = &model.Comment{ID: n.CommentID, PostID: n.PostID, ParentID: n.ParentID}
+	default:
+		err = fmt.Errorf("unknown event type: %s", n.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
@@ -0,0 +1,37 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"postsandcomments/internal/graph/model"
+	"strconv"
+)
+
+func (r *commentResolver) Replies(ctx context.Context, obj *model.Comment, first *int, after *string) (*model.CommentConnection, error) {
+	connection, err := r.commentConnection(ctx, obj.PostID, &obj.ID, first, after)
+	if err != nil {
+		r.Logger.Errorf("error to get replies to comment: %v", err)
+		return nil, fmt.Errorf("error to get replies to comment: %w", err)
+	}
+
+	return connection, nil
+}
+
+// EventID is the position of the comment in the commentAdded stream of its post.
+func (r *commentResolver) EventID(ctx context.Context, obj *model.Comment) (string, error) {
+	return strconv.FormatInt(obj.Seq, 10), nil
+}
+
+func (r *commentResolver) Author(ctx context.Context, obj *model.Comment) (*model.User, error) {
+	if obj.AuthorID == nil {
+		return nil, nil
+	}
+
+	user, err := r.userByID(ctx, *obj.AuthorID)
+	if err != nil {
+		r.Logger.Errorf("error to get author of comment: %v", err)
+		return nil, fmt.Errorf("error to get author of comment: %w", err)
+	}
+
+	return user, nil
+}
@@ -0,0 +1,81 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/graph/model"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/sirupsen/logrus"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// Error codes of failed resolvers, sent in extensions.code.
+const (
+	NotFound           = "NOT_FOUND"
+	CommentsDisabled   = "COMMENTS_DISABLED"
+	ValidationFailed   = "VALIDATION_FAILED"
+	ParentPostMismatch = "PARENT_POST_MISMATCH"
+	Forbidden          = "FORBIDDEN"
+	Internal           = "INTERNAL"
+)
+
+// Error is a failure caused by the request, clients get its message and code.
+// Other errors are internal, clients get only Internal.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(code string, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// NewErrorPresenter adds codes to the errors of resolvers. Errors wrapping
+// db.ErrNotFound and db.ErrParentPostMismatch keep the message of the db error,
+// invalid DateTime arguments are failed validations.
+// Internal errors are logged and their details are hidden.
+func NewErrorPresenter(logger *logrus.Logger) graphql.ErrorPresenterFunc {
+	return func(ctx context.Context, err error) *gqlerror.Error {
+		gqlErr := graphql.DefaultErrorPresenter(ctx, err)
+		if _, ok := gqlErr.Extensions["code"]; ok {
+			return gqlErr
+		}
+
+		var domainErr *Error
+		var notFoundErr *db.NotFoundError
+		switch {
+		case errors.As(err, &domainErr):
+			return withCode(gqlErr, domainErr.Code, domainErr.Message)
+		case errors.As(err, &notFoundErr):
+			return withCode(gqlErr, NotFound, notFoundErr.Error())
+		case errors.Is(err, db.ErrParentPostMismatch):
+			return withCode(gqlErr, ParentPostMismatch, db.ErrParentPostMismatch.Error())
+		case errors.Is(err, model.ErrInvalidDateTime):
+			return withCode(gqlErr, ValidationFailed, gqlErr.Message)
+		case gqlErr.Err == nil:
+			// Made by gqlgen, like errors of invalid arguments.
+			return gqlErr
+		}
+
+		logger.Errorf("internal error at %s: %v", gqlErr.Path, err)
+		return withCode(gqlErr, Internal, "internal server error")
+	}
+}
+
+// withCode returns a copy, so the error returned by the resolver is not changed.
+func withCode(gqlErr *gqlerror.Error, code string, message string) *gqlerror.Error {
+	presented := *gqlErr
+	presented.Message = message
+	presented.Extensions = map[string]interface{}{"code": code}
+	for key, value := range gqlErr.Extensions {
+		presented.Extensions[key] = value
+	}
+	return &presented
+}
@@ -0,0 +1,112 @@
+package graph_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/graph/model"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// brokenDB fails GetPosts with an error clients must not see.
+type brokenDB struct {
+	db.Database
+}
+
+func (d brokenDB) GetPosts(ctx context.Context, query db.PostQuery) ([]*model.Post, error) {
+	return nil, errors.New("dial tcp 10.0.0.5:5432: connection refused")
+}
+
+func TestErrorCodes(t *testing.T) {
+	database := db.NewInMemoryDB()
+	ctx := context.Background()
+	post := &model.Post{ID: uuid.New().String(), Title: "Post", Body: "Body", AllowComments: true}
+	closedPost := &model.Post{ID: uuid.New().String(), Title: "Closed", Body: "Body"}
+	require.NoError(t, database.CreatePost(ctx, post))
+	require.NoError(t, database.CreatePost(ctx, closedPost))
+	otherComment := &model.Comment{ID: uuid.New().String(), PostID: closedPost.ID, Body: "Other"}
+	require.NoError(t, database.CreateComment(ctx, closedPost, otherComment))
+	missingID := uuid.New().String()
+
+	createComment := `mutation($postId: ID!, $body: String!, $parentId: ID) {
+		createComment(postId: $postId, body: $body, parentId: $parentId) { id }
+	}`
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		code      string
+		message   string
+	}{
+		{
+			"NotFound", `query($id: ID!) { post(id: $id) { id } }`,
+			map[string]interface{}{"id": missingID},
+			graph.NotFound, "no posts with this id: " + missingID,
+		},
+		{
+			"CommentsDisabled", createComment,
+			map[string]interface{}{"postId": closedPost.ID, "body": "Comment"},
+			graph.CommentsDisabled, "error to create comment: not allowed comments for post",
+		},
+		{
+			"ValidationFailed", createComment,
+			map[string]interface{}{"postId": post.ID, "body": strings.Repeat("a", graph.MaxLengthOfComment+1)},
+			graph.ValidationFailed, "error to create comment: size of comment more than max size",
+		},
+		{
+			"InvalidDateTime", `{ posts(filter: {createdAfter: "yesterday"}) { edges { node { id } } } }`,
+			nil,
+			graph.ValidationFailed, "DateTime should be a time in RFC 3339 format: yesterday",
+		},
+		{
+			"ParentPostMismatch", createComment,
+			map[string]interface{}{"postId": post.ID, "body": "Reply", "parentId": otherComment.ID},
+			graph.ParentPostMismatch, "postID for parent and child comment should be the same",
+		},
+		{
+			"Forbidden", `mutation($id: ID!) { deletePost(id: $id) }`,
+			map[string]interface{}{"id": post.ID},
+			graph.Forbidden, "error to delete post: only the author can delete the post",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := execute(t, database, &graph.Limits{}, tt.query, tt.variables)
+			assert.Equal(t, http.StatusOK, code)
+			require.Len(t, resp.Errors, 1)
+			assert.Equal(t, tt.message, resp.Errors[0].Message)
+			assert.Equal(t, map[string]interface{}{"code": tt.code}, resp.Errors[0].Extensions)
+		})
+	}
+}
+
+func TestInternalErrorsAreHidden(t *testing.T) {
+	var logs bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&logs)
+
+	presenter := graph.NewErrorPresenter(logger)
+	_, err := brokenDB{}.GetPosts(context.Background(), db.PostQuery{})
+	presented := presenter(context.Background(), err)
+
+	assert.Equal(t, "internal server error", presented.Message)
+	assert.Equal(t, map[string]interface{}{"code": graph.Internal}, presented.Extensions)
+	assert.ErrorIs(t, presented, err)
+	assert.Contains(t, logs.String(), "connection refused")
+
+	code, resp := execute(t, brokenDB{Database: db.NewInMemoryDB()}, &graph.Limits{}, `{ posts { edges { node { id } } } }`, nil)
+	assert.Equal(t, http.StatusOK, code)
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, "internal server error", resp.Errors[0].Message)
+	assert.Equal(t, graph.Internal, resp.Errors[0].Extensions["code"])
+}
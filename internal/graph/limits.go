@@ -0,0 +1,177 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"postsandcomments/internal/graph/model"
+	"strings"
+	"time"
+
+	"github.com/99designs/gqlgen/complexity"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// Error codes of operations stopped by Limits.
+const (
+	QueryTooDeep     = "QUERY_TOO_DEEP"
+	QueryTooComplex  = "QUERY_TOO_COMPLEX"
+	OperationTimeout = "OPERATION_TIMEOUT"
+)
+
+func init() {
+	// Rejected operations never run, so like invalid ones they are answered with 422.
+	errcode.RegisterErrorType(QueryTooDeep, errcode.KindProtocol)
+	errcode.RegisterErrorType(QueryTooComplex, errcode.KindProtocol)
+}
+
+// NewComplexity weighs list fields by how many items they may return: the
+// requested page size, or DefaultPageSize for lists that have none. Other
+// fields cost 1 plus their selections.
+func NewComplexity() ComplexityRoot {
+	var c ComplexityRoot
+	c.Query.Posts = func(childComplexity int, first *int, after *string, orderBy *model.PostOrder, filter *model.PostFilter) int {
+		return listComplexity(listSize(first), childComplexity)
+	}
+	c.Query.WebhookDeliveries = func(childComplexity int, webhookID *string, limit *int) int {
+		return listComplexity(listSize(limit), childComplexity)
+	}
+	c.Post.Comments = func(childComplexity int, first *int, after *string) int {
+		return listComplexity(listSize(first), childComplexity)
+	}
+	c.Comment.Replies = func(childComplexity int, first *int, after *string) int {
+		return listComplexity(listSize(first), childComplexity)
+	}
+	c.Comment.Children = func(childComplexity int) int {
+		return listComplexity(DefaultPageSize, childComplexity)
+	}
+	return c
+}
+
+// listSize is the largest page for an invalid size, the resolver rejects it anyway.
+func listSize(first *int) int {
+	size, err := pageSize(first)
+	if err != nil {
+		return MaxPageSize
+	}
+	return size
+}
+
+// listComplexity saturates instead of overflowing, so a deep enough query
+// can't wrap around to a small cost.
+func listComplexity(size, childComplexity int) int {
+	if size > 0 && childComplexity > (math.MaxInt-1)/size {
+		return math.MaxInt
+	}
+	return 1 + size*childComplexity
+}
+
+// Limits is a gqlgen extension that rejects operations nested deeper than
+// MaxDepth or costing more than MaxComplexity before they run, and stops
+// waiting for queries and mutations after Timeout. Zero disables a limit.
+type Limits struct {
+	MaxDepth      int
+	MaxComplexity int
+	// Timeout cancels the context of resolvers, subscriptions are not limited.
+	Timeout time.Duration
+
+	schema graphql.ExecutableSchema
+}
+
+var (
+	_ graphql.HandlerExtension        = &Limits{}
+	_ graphql.OperationContextMutator = &Limits{}
+	_ graphql.OperationInterceptor    = &Limits{}
+)
+
+func (l *Limits) ExtensionName() string {
+	return "Limits"
+}
+
+func (l *Limits) Validate(schema graphql.ExecutableSchema) error {
+	l.schema = schema
+	return nil
+}
+
+func (l *Limits) MutateOperationContext(ctx context.Context, oc *graphql.OperationContext) *gqlerror.Error {
+	if oc.Operation == nil {
+		return nil
+	}
+
+	if l.MaxDepth > 0 {
+		if depth := selectionDepth(oc.Operation.SelectionSet); depth > l.MaxDepth {
+			return &gqlerror.Error{
+				Message: fmt.Sprintf("operation has depth %d, which exceeds the limit of %d", depth, l.MaxDepth),
+				Extensions: map[string]interface{}{
+					"code":     QueryTooDeep,
+					"depth":    depth,
+					"maxDepth": l.MaxDepth,
+				},
+			}
+		}
+	}
+
+	if l.MaxComplexity > 0 {
+		if cost := complexity.Calculate(l.schema, oc.Operation, oc.Variables); cost > l.MaxComplexity {
+			return &gqlerror.Error{
+				Message: fmt.Sprintf("operation has complexity %d, which exceeds the limit of %d", cost, l.MaxComplexity),
+				Extensions: map[string]interface{}{
+					"code":          QueryTooComplex,
+					"complexity":    cost,
+					"maxComplexity": l.MaxComplexity,
+				},
+			}
+		}
+	}
+
+	return nil
+}
+
+// selectionDepth counts nested fields, introspection is not counted since
+// clients can't shorten the queries of their tooling.
+func selectionDepth(selectionSet ast.SelectionSet) int {
+	depth := 0
+	for _, selection := range selectionSet {
+		var d int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(selection.Name, "__") {
+				continue
+			}
+			d = 1 + selectionDepth(selection.SelectionSet)
+		case *ast.InlineFragment:
+			d = selectionDepth(selection.SelectionSet)
+		case *ast.FragmentSpread:
+			if selection.Definition != nil {
+				d = selectionDepth(selection.Definition.SelectionSet)
+			}
+		}
+		depth = max(depth, d)
+	}
+	return depth
+}
+
+func (l *Limits) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	operation := graphql.GetOperationContext(ctx).Operation
+	if l.Timeout <= 0 || operation == nil || operation.Operation == ast.Subscription {
+		return next(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, l.Timeout)
+	handler := next(ctx)
+	return func(responseCtx context.Context) *graphql.Response {
+		defer cancel()
+
+		resp := handler(responseCtx)
+		if resp != nil && ctx.Err() == context.DeadlineExceeded {
+			resp.Errors = append(resp.Errors, &gqlerror.Error{
+				Message:    fmt.Sprintf("operation timed out after %s", l.Timeout),
+				Extensions: map[string]interface{}{"code": OperationTimeout},
+			})
+		}
+		return resp
+	}
+}
@@ -0,0 +1,147 @@
+package graph_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/graph/model"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// slowDB makes GetPosts wait until its context is done.
+type slowDB struct {
+	db.Database
+}
+
+func (d slowDB) GetPosts(ctx context.Context, query db.PostQuery) ([]*model.Post, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+type graphQLResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors gqlerror.List          `json:"errors"`
+}
+
+func execute(t *testing.T, database db.Database, limits *graph.Limits, query string, variables map[string]interface{}) (int, graphQLResponse) {
+	resolver := newResolver(t)
+	resolver.DataBase = database
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{
+		Resolvers:  resolver,
+		Complexity: graph.NewComplexity(),
+	}))
+	srv.SetErrorPresenter(graph.NewErrorPresenter(resolver.Logger))
+	srv.AddTransport(transport.POST{})
+	srv.Use(extension.Introspection{})
+	srv.Use(limits)
+	srv.AroundOperations(graph.BatchUserLookups(database))
+
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+
+	var resp graphQLResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&resp))
+	return recorder.Code, resp
+}
+
+func TestMaxDepth(t *testing.T) {
+	limits := &graph.Limits{MaxDepth: 5}
+	tests := []struct {
+		name  string
+		query string
+		depth int
+	}{
+		{"Fields", `{ post(id: "id") { comments { edges { node { replies { edges { node { id } } } } } } } }`, 8},
+		{"Fragments", `{ post(id: "id") { ...comments } } fragment comments on Post { comments { edges { node { ... on Comment { children { id } } } } } }`, 6},
+		{"Children", `{ post(id: "id") { comments { edges { node { children { children { id } } } } } } }`, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := execute(t, db.NewInMemoryDB(), limits, tt.query, nil)
+			assert.Equal(t, http.StatusUnprocessableEntity, code)
+			require.Len(t, resp.Errors, 1)
+			assert.Equal(t, map[string]interface{}{
+				"code":     graph.QueryTooDeep,
+				"depth":    float64(tt.depth),
+				"maxDepth": float64(5),
+			}, resp.Errors[0].Extensions)
+		})
+	}
+
+	// Introspection is not counted.
+	code, resp := execute(t, db.NewInMemoryDB(), limits, `{ __schema { types { fields { type { ofType { ofType { name } } } } } } }`, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, resp.Errors)
+}
+
+func TestMaxComplexity(t *testing.T) {
+	limits := &graph.Limits{MaxComplexity: 1000}
+	query := `query($first: Int) { posts { edges { node { id comments(first: $first) { edges { node { id body } } } } } } }`
+
+	// Every post costs 3 for its edge, node and id, and 1 + first * 4 for its comments.
+	code, resp := execute(t, db.NewInMemoryDB(), limits, query, map[string]interface{}{"first": 5})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, resp.Errors)
+
+	code, resp = execute(t, db.NewInMemoryDB(), limits, query, map[string]interface{}{"first": 100})
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, map[string]interface{}{
+		"code":          graph.QueryTooComplex,
+		"complexity":    float64(1 + graph.DefaultPageSize*(3+1+100*4)),
+		"maxComplexity": float64(1000),
+	}, resp.Errors[0].Extensions)
+
+	// Without a page size the default one is assumed.
+	code, _ = execute(t, db.NewInMemoryDB(), limits, query, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+
+	// Fewer posts leave room for more comments of each.
+	code, resp = execute(t, db.NewInMemoryDB(), limits, strings.Replace(query, "posts", "posts(first: 2)", 1), map[string]interface{}{"first": 100})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, resp.Errors)
+}
+
+func TestComplexityDoesNotOverflow(t *testing.T) {
+	query := `{ post(id: "id") { comments { edges { node { ` +
+		strings.Repeat("children { ", 20) + "id" + strings.Repeat(" }", 20) +
+		` } } } } }`
+
+	code, resp := execute(t, db.NewInMemoryDB(), &graph.Limits{MaxComplexity: 1000}, query, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, graph.QueryTooComplex, resp.Errors[0].Extensions["code"])
+}
+
+func TestOperationTimeout(t *testing.T) {
+	database := slowDB{Database: db.NewInMemoryDB()}
+	limits := &graph.Limits{Timeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	code, resp := execute(t, database, limits, `{ posts { edges { node { id } } } }`, nil)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, http.StatusOK, code)
+
+	codes := make([]interface{}, 0, len(resp.Errors))
+	for _, err := range resp.Errors {
+		codes = append(codes, err.Extensions["code"])
+	}
+	assert.Contains(t, codes, graph.OperationTimeout)
+}
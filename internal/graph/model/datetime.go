@@ -0,0 +1,36 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+)
+
+// ErrInvalidDateTime is returned for DateTime values that are not RFC 3339 times.
+var ErrInvalidDateTime = errors.New("DateTime should be a time in RFC 3339 format")
+
+// MarshalDateTime writes the time in UTC, with fractions of a second only if it has them.
+func MarshalDateTime(t time.Time) graphql.Marshaler {
+	return graphql.WriterFunc(func(w io.Writer) {
+		_, _ = io.WriteString(w, strconv.Quote(t.UTC().Format(time.RFC3339Nano)))
+	})
+}
+
+// UnmarshalDateTime accepts RFC 3339 times in any zone.
+func UnmarshalDateTime(v interface{}) (time.Time, error) {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidDateTime, v)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidDateTime, s)
+	}
+
+	return t, nil
+}
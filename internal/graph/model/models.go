@@ -0,0 +1,55 @@
+package model
+
+import "time"
+
+type Post struct {
+	ID            string    `json:"id"`
+	Title         string    `json:"title"`
+	Body          string    `json:"body"`
+	AllowComments bool      `json:"allowComments"`
+	AuthorID      *string   `json:"-"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	// CommentCount doesn't count deleted comments. LastActivityAt is when the
+	// latest comment was created, or CreatedAt if there are none.
+	CommentCount   int       `json:"-"`
+	LastActivityAt time.Time `json:"-"`
+}
+
+type Comment struct {
+	ID        string     `json:"id"`
+	PostID    string     `json:"postId"`
+	Body      string     `json:"body"`
+	ParentID  *string    `json:"parentId,omitempty"`
+	Children  []*Comment `json:"children"`
+	AuthorID  *string    `json:"-"`
+	Deleted   bool       `json:"deleted"`
+	Seq       int64      `json:"-"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+type User struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type Webhook struct {
+	ID     string         `json:"id"`
+	URL    string         `json:"url"`
+	Events []WebhookEvent `json:"events"`
+	// PostID limits the webhook to events of one post.
+	PostID *string `json:"postId,omitempty"`
+	Secret string  `json:"secret"`
+}
+
+// WebhookDelivery is a delivery that failed after all attempts.
+type WebhookDelivery struct {
+	ID        string       `json:"id"`
+	WebhookID string       `json:"webhookId"`
+	Event     WebhookEvent `json:"event"`
+	Payload   string       `json:"payload"`
+	Attempts  int          `json:"attempts"`
+	LastError string       `json:"lastError"`
+	FailedAt  time.Time    `json:"failedAt"`
+}
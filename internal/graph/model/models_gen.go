@@ -2,23 +2,52 @@
 
 package model
 
-type Comment struct {
-	ID       string     `json:"id"`
-	PostID   string     `json:"postId"`
-	Body     string     `json:"body"`
-	ParentID *string    `json:"parentId,omitempty"`
-	Children []*Comment `json:"children"`
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+type CommentConnection struct {
+	Edges    []*CommentEdge `json:"edges"`
+	PageInfo *PageInfo      `json:"pageInfo"`
+}
+
+type CommentEdge struct {
+	Cursor string   `json:"cursor"`
+	Node   *Comment `json:"node"`
+}
+
+type CreatedWebhook struct {
+	Webhook *Webhook `json:"webhook"`
+	Secret  string   `json:"secret"`
 }
 
 type Mutation struct {
 }
 
-type Post struct {
-	ID            string     `json:"id"`
-	Title         string     `json:"title"`
-	Body          string     `json:"body"`
-	Comments      []*Comment `json:"comments"`
-	AllowComments bool       `json:"allowComments"`
+type PageInfo struct {
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
+	StartCursor     *string `json:"startCursor,omitempty"`
+	EndCursor       *string `json:"endCursor,omitempty"`
+}
+
+type PostConnection struct {
+	Edges    []*PostEdge `json:"edges"`
+	PageInfo *PageInfo   `json:"pageInfo"`
+}
+
+type PostEdge struct {
+	Cursor string `json:"cursor"`
+	Node   *Post  `json:"node"`
+}
+
+type PostFilter struct {
+	AuthorID      *string    `json:"authorId,omitempty"`
+	AllowComments *bool      `json:"allowComments,omitempty"`
+	CreatedAfter  *time.Time `json:"createdAfter,omitempty"`
 }
 
 type Query struct {
@@ -26,3 +55,89 @@ type Query struct {
 
 type Subscription struct {
 }
+
+type PostOrder string
+
+const (
+	PostOrderNewest         PostOrder = "NEWEST"
+	PostOrderOldest         PostOrder = "OLDEST"
+	PostOrderMostCommented  PostOrder = "MOST_COMMENTED"
+	PostOrderRecentActivity PostOrder = "RECENT_ACTIVITY"
+)
+
+var AllPostOrder = []PostOrder{
+	PostOrderNewest,
+	PostOrderOldest,
+	PostOrderMostCommented,
+	PostOrderRecentActivity,
+}
+
+func (e PostOrder) IsValid() bool {
+	switch e {
+	case PostOrderNewest, PostOrderOldest, PostOrderMostCommented, PostOrderRecentActivity:
+		return true
+	}
+	return false
+}
+
+func (e PostOrder) String() string {
+	return string(e)
+}
+
+func (e *PostOrder) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = PostOrder(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid PostOrder", str)
+	}
+	return nil
+}
+
+func (e PostOrder) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type WebhookEvent string
+
+const (
+	WebhookEventPostAdded    WebhookEvent = "POST_ADDED"
+	WebhookEventCommentAdded WebhookEvent = "COMMENT_ADDED"
+)
+
+var AllWebhookEvent = []WebhookEvent{
+	WebhookEventPostAdded,
+	WebhookEventCommentAdded,
+}
+
+func (e WebhookEvent) IsValid() bool {
+	switch e {
+	case WebhookEventPostAdded, WebhookEventCommentAdded:
+		return true
+	}
+	return false
+}
+
+func (e WebhookEvent) String() string {
+	return string(e)
+}
+
+func (e *WebhookEvent) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WebhookEvent(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WebhookEvent", str)
+	}
+	return nil
+}
+
+func (e WebhookEvent) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
@@ -3,7 +3,10 @@ package graph
 import (
 	"context"
 	"fmt"
+	"postsandcomments/internal/auth"
+	"postsandcomments/internal/eventbus"
 	"postsandcomments/internal/graph/model"
+	"postsandcomments/internal/ratelimit"
 	"unicode/utf8"
 
 	"github.com/google/uuid"
@@ -12,73 +15,243 @@ import (
 const MaxLengthOfComment = 2000
 
 func (r *mutationResolver) CreatePost(ctx context.Context, title string, body string, allowComments bool) (*model.Post, error) {
+	if err := r.allow(ctx, ratelimit.CreatePost); err != nil {
+		return nil, err
+	}
+
+	authorID, err := r.saveAuthor(ctx)
+	if err != nil {
+		r.Logger.Errorf("error to create post: %v", err)
+		return nil, fmt.Errorf("error to create post: %w", err)
+	}
+
+	now := r.now()
 	post := &model.Post{
 		ID:            uuid.New().String(),
 		Title:         title,
 		Body:          body,
-		Comments:      make([]*model.Comment, 0),
 		AllowComments: allowComments,
+		AuthorID:      authorID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
 	}
 
-	err := r.DataBase.CreatePost(ctx, post)
+	err = r.DataBase.CreatePost(ctx, post)
 	if err != nil {
 		r.Logger.Errorf("error to create post: %v", err)
-		return nil, fmt.Errorf("error to create post: %v", err)
+		return nil, fmt.Errorf("error to create post: %w", err)
+	}
+
+	event := &eventbus.Event{
+		Type:   eventbus.PostAdded,
+		PostID: post.ID,
+		Post:   post,
+	}
+	r.SubscriptionManager.Publish(ctx, event)
+	if r.Webhooks != nil {
+		r.Webhooks.Dispatch(ctx, event)
 	}
 
 	r.Logger.Infof("post with id = %s created", post.ID)
 	return post, nil
 }
 
+func (r *mutationResolver) UpdatePost(ctx context.Context, id string, title *string, body *string, allowComments *bool) (*model.Post, error) {
+	post, err := r.DataBase.GetPostById(ctx, id)
+	if err != nil {
+		r.Logger.Errorf("error to get post by id to update post: %v", err)
+		return nil, fmt.Errorf("error to get post by id to update post: %w", err)
+	}
+
+	if !isAuthor(ctx, post.AuthorID) {
+		r.Logger.Errorf("error to update post: only the author can update post with id = %s", id)
+		return nil, newError(Forbidden, "error to update post: only the author can update the post")
+	}
+
+	if title != nil {
+		post.Title = *title
+	}
+	if body != nil {
+		post.Body = *body
+	}
+	if allowComments != nil {
+		post.AllowComments = *allowComments
+	}
+	post.UpdatedAt = r.now()
+
+	err = r.DataBase.UpdatePost(ctx, post)
+	if err != nil {
+		r.Logger.Errorf("error to update post: %v", err)
+		return nil, fmt.Errorf("error to update post: %w", err)
+	}
+
+	r.Logger.Infof("post with id = %s updated", post.ID)
+	return post, nil
+}
+
+func (r *mutationResolver) DeletePost(ctx context.Context, id string) (bool, error) {
+	post, err := r.DataBase.GetPostById(ctx, id)
+	if err != nil {
+		r.Logger.Errorf("error to get post by id to delete post: %v", err)
+		return false, fmt.Errorf("error to get post by id to delete post: %w", err)
+	}
+
+	if !isAuthor(ctx, post.AuthorID) {
+		r.Logger.Errorf("error to delete post: only the author can delete post with id = %s", id)
+		return false, newError(Forbidden, "error to delete post: only the author can delete the post")
+	}
+
+	err = r.DataBase.DeletePost(ctx, id)
+	if err != nil {
+		r.Logger.Errorf("error to delete post: %v", err)
+		return false, fmt.Errorf("error to delete post: %w", err)
+	}
+
+	r.Logger.Infof("post with id = %s deleted", id)
+	return true, nil
+}
+
 func (r *mutationResolver) CreateComment(ctx context.Context, postID string, body string, parentID *string) (*model.Comment, error) {
+	if err := r.allow(ctx, ratelimit.CreateComment); err != nil {
+		return nil, err
+	}
+
+	now := r.now()
 	comment := &model.Comment{
-		ID:       uuid.New().String(),
-		PostID:   postID,
-		Body:     body,
-		ParentID: parentID,
-		Children: make([]*model.Comment, 0),
+		ID:        uuid.New().String(),
+		PostID:    postID,
+		Body:      body,
+		ParentID:  parentID,
+		Children:  make([]*model.Comment, 0),
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
 
 	if utf8.RuneCountInString(body) > MaxLengthOfComment {
 		r.Logger.Errorf("error to create comment: size of comment more than max size")
-		return nil, fmt.Errorf("error to create comment: size of comment more than max size")
+		return nil, newError(ValidationFailed, "error to create comment: size of comment more than max size")
 	}
 
-	post, err := r.DataBase.GetPostById(ctx, postID, nil, nil)
+	post, err := r.DataBase.GetPostById(ctx, postID)
 	if err != nil {
 		r.Logger.Errorf("error to get post by id to create comment: %v", err)
-		return nil, fmt.Errorf("error to get post by id to create comment: %v", err)
+		return nil, fmt.Errorf("error to get post by id to create comment: %w", err)
 	}
 
 	if comment.ParentID != nil {
 		parentComment, err := r.DataBase.GetCommentById(ctx, *comment.ParentID)
 		if err != nil {
 			r.Logger.Errorf("error to get parent comment by id to create comment: %v", err)
-			return nil, fmt.Errorf("error to get parent comment by id to create comment: %v", err)
+			return nil, fmt.Errorf("error to get parent comment by id to create comment: %w", err)
 		}
 		if parentComment.PostID != comment.PostID {
 			r.Logger.Errorf("postID for parent and child comment should be the same")
-			return nil, fmt.Errorf("postID for parent and child comment should be the same")
+			return nil, newError(ParentPostMismatch, "postID for parent and child comment should be the same")
 		}
 	}
 
 	if !post.AllowComments {
 		r.Logger.Errorf("error to create comment: not allowed comments for post")
-		return nil, fmt.Errorf("error to create comment: not allowed comments for post")
+		return nil, newError(CommentsDisabled, "error to create comment: not allowed comments for post")
+	}
+
+	comment.AuthorID, err = r.saveAuthor(ctx)
+	if err != nil {
+		r.Logger.Errorf("error to create comment: %v", err)
+		return nil, fmt.Errorf("error to create comment: %w", err)
 	}
 
 	err = r.DataBase.CreateComment(ctx, post, comment)
 	if err != nil {
 		r.Logger.Errorf("error to create comment: %v", err)
-		return nil, fmt.Errorf("error to create comment: %v", err)
+		return nil, fmt.Errorf("error to create comment: %w", err)
 	}
 
-	event := &CommentEvent{
+	event := &eventbus.Event{
+		Type:    eventbus.CommentAdded,
 		PostID:  postID,
 		Comment: comment,
 	}
-	r.SubscriptionManager.Publish(event)
+	r.SubscriptionManager.Publish(ctx, event)
+	if r.Webhooks != nil {
+		r.Webhooks.Dispatch(ctx, event)
+	}
 
 	r.Logger.Infof("comment with id = %s created", comment.ID)
 	return comment, err
 }
+
+func (r *mutationResolver) UpdateComment(ctx context.Context, id string, body string) (*model.Comment, error) {
+	if utf8.RuneCountInString(body) > MaxLengthOfComment {
+		r.Logger.Errorf("error to update comment: size of comment more than max size")
+		return nil, newError(ValidationFailed, "error to update comment: size of comment more than max size")
+	}
+
+	comment, err := r.DataBase.GetCommentById(ctx, id)
+	if err != nil {
+		r.Logger.Errorf("error to get comment by id to update comment: %v", err)
+		return nil, fmt.Errorf("error to get comment by id to update comment: %w", err)
+	}
+
+	if comment.Deleted {
+		r.Logger.Errorf("error to update comment: comment with id = %s is deleted", id)
+		return nil, newError(ValidationFailed, "error to update comment: comment is deleted")
+	}
+
+	if !isAuthor(ctx, comment.AuthorID) {
+		r.Logger.Errorf("error to update comment: only the author can update comment with id = %s", id)
+		return nil, newError(Forbidden, "error to update comment: only the author can update the comment")
+	}
+
+	comment.Body = body
+	comment.UpdatedAt = r.now()
+	err = r.DataBase.UpdateComment(ctx, comment)
+	if err != nil {
+		r.Logger.Errorf("error to update comment: %v", err)
+		return nil, fmt.Errorf("error to update comment: %w", err)
+	}
+
+	r.SubscriptionManager.Publish(ctx, &eventbus.Event{
+		Type:    eventbus.CommentUpdated,
+		PostID:  comment.PostID,
+		Comment: comment,
+	})
+
+	r.Logger.Infof("comment with id = %s updated", comment.ID)
+	return comment, nil
+}
+
+func (r *mutationResolver) DeleteComment(ctx context.Context, id string) (bool, error) {
+	comment, err := r.DataBase.GetCommentById(ctx, id)
+	if err != nil {
+		r.Logger.Errorf("error to get comment by id to delete comment: %v", err)
+		return false, fmt.Errorf("error to get comment by id to delete comment: %w", err)
+	}
+
+	if !isAuthor(ctx, comment.AuthorID) {
+		r.Logger.Errorf("error to delete comment: only the author can delete comment with id = %s", id)
+		return false, newError(Forbidden, "error to delete comment: only the author can delete the comment")
+	}
+
+	err = r.DataBase.DeleteComment(ctx, id)
+	if err != nil {
+		r.Logger.Errorf("error to delete comment: %v", err)
+		return false, fmt.Errorf("error to delete comment: %w", err)
+	}
+
+	r.SubscriptionManager.Publish(ctx, &eventbus.Event{
+		Type:    eventbus.CommentDeleted,
+		PostID:  comment.PostID,
+		Comment: &model.Comment{ID: comment.ID, PostID: comment.PostID, ParentID: comment.ParentID},
+	})
+
+	r.Logger.Infof("comment with id = %s deleted", id)
+	return true, nil
+}
+
+// isAuthor reports whether the user making the request wrote the post or comment.
+// Posts and comments without author can't be changed by anyone.
+func isAuthor(ctx context.Context, authorID *string) bool {
+	user := auth.ForContext(ctx)
+	return user != nil && authorID != nil && *authorID == user.ID
+}
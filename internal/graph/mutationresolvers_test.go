@@ -0,0 +1,55 @@
+package graph_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/auth"
+	"postsandcomments/internal/graph"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestamps(t *testing.T) {
+	resolver := newResolver(t)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.FixedZone("UTC+3", 3*60*60))
+	resolver.Now = func() time.Time { return now }
+	ctx := auth.WithUser(context.Background(), &auth.User{ID: "test_user_id", Name: "Test User"})
+
+	post, err := resolver.Mutation().CreatePost(ctx, "Post", "Body", true)
+	require.NoError(t, err)
+	assert.Equal(t, now.UTC(), post.CreatedAt)
+	assert.Equal(t, now.UTC(), post.UpdatedAt)
+	comment, err := resolver.Mutation().CreateComment(ctx, post.ID, "Comment", nil)
+	require.NoError(t, err)
+	assert.Equal(t, now.UTC(), comment.CreatedAt)
+	assert.Equal(t, now.UTC(), comment.UpdatedAt)
+
+	now = now.Add(time.Hour)
+	_, err = resolver.Mutation().UpdatePost(ctx, post.ID, nil, nil, nil)
+	require.NoError(t, err)
+	_, err = resolver.Mutation().UpdateComment(ctx, comment.ID, "Updated")
+	require.NoError(t, err)
+
+	// Times are returned in UTC.
+	code, resp := execute(t, resolver.DataBase, &graph.Limits{}, `query($id: ID!) {
+		post(id: $id) { createdAt updatedAt comments { edges { node { createdAt updatedAt } } } }
+	}`, map[string]interface{}{"id": post.ID})
+	assert.Equal(t, http.StatusOK, code)
+	require.Empty(t, resp.Errors)
+	assert.Equal(t, map[string]interface{}{
+		"createdAt": "2024-06-01T09:00:00Z",
+		"updatedAt": "2024-06-01T10:00:00Z",
+		"comments": map[string]interface{}{
+			"edges": []interface{}{
+				map[string]interface{}{"node": map[string]interface{}{
+					"createdAt": "2024-06-01T09:00:00Z",
+					"updatedAt": "2024-06-01T10:00:00Z",
+				}},
+			},
+		},
+	}, resp.Data["post"])
+}
@@ -0,0 +1,191 @@
+package graph
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/graph/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+
+	commentCursorPrefix = "comment:"
+	postCursorPrefix    = "post:"
+)
+
+func encodeCommentCursor(seq int64) string {
+	return base64.URLEncoding.EncodeToString([]byte(commentCursorPrefix + strconv.FormatInt(seq, 10)))
+}
+
+func decodeCommentCursor(cursor string) (int64, error) {
+	decoded, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(decoded), commentCursorPrefix) {
+		return 0, newError(ValidationFailed, "invalid cursor: %s", cursor)
+	}
+
+	seq, err := strconv.ParseInt(strings.TrimPrefix(string(decoded), commentCursorPrefix), 10, 64)
+	if err != nil {
+		return 0, newError(ValidationFailed, "invalid cursor: %s", cursor)
+	}
+
+	return seq, nil
+}
+
+// encodePostCursor keeps the whole sort key of the post, so the next page
+// starts at the same place even if the post is deleted or gets comments.
+func encodePostCursor(order model.PostOrder, post *model.Post) string {
+	fields := []string{
+		order.String(),
+		post.ID,
+		post.CreatedAt.Format(time.RFC3339Nano),
+		strconv.Itoa(post.CommentCount),
+		post.LastActivityAt.Format(time.RFC3339Nano),
+	}
+	return base64.URLEncoding.EncodeToString([]byte(postCursorPrefix + strings.Join(fields, ",")))
+}
+
+// decodePostCursor accepts only cursors of the same order, the sort key of
+// another order doesn't point to a place in this one.
+func decodePostCursor(order model.PostOrder, cursor string) (*model.Post, error) {
+	decoded, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(decoded), postCursorPrefix) {
+		return nil, newError(ValidationFailed, "invalid cursor: %s", cursor)
+	}
+
+	fields := strings.Split(strings.TrimPrefix(string(decoded), postCursorPrefix), ",")
+	if len(fields) != 5 || fields[0] != order.String() {
+		return nil, newError(ValidationFailed, "invalid cursor: %s", cursor)
+	}
+	_, idErr := uuid.Parse(fields[1])
+	createdAt, createdAtErr := time.Parse(time.RFC3339Nano, fields[2])
+	commentCount, commentCountErr := strconv.Atoi(fields[3])
+	lastActivityAt, lastActivityAtErr := time.Parse(time.RFC3339Nano, fields[4])
+	if errors.Join(idErr, createdAtErr, commentCountErr, lastActivityAtErr) != nil {
+		return nil, newError(ValidationFailed, "invalid cursor: %s", cursor)
+	}
+
+	return &model.Post{
+		ID:             fields[1],
+		CreatedAt:      createdAt,
+		CommentCount:   commentCount,
+		LastActivityAt: lastActivityAt,
+	}, nil
+}
+
+func pageSize(first *int) (int, error) {
+	if first == nil {
+		return DefaultPageSize, nil
+	}
+	if *first < 0 || *first > MaxPageSize {
+		return 0, newError(ValidationFailed, "first should be between 0 and %d", MaxPageSize)
+	}
+	return *first, nil
+}
+
+// commentConnection loads one page of replies to parentID, or of top-level comments
+// of the post if parentID is nil. One extra comment is requested to fill hasNextPage.
+func (r *Resolver) commentConnection(ctx context.Context, postID string, parentID *string, first *int, after *string) (*model.CommentConnection, error) {
+	limit, err := pageSize(first)
+	if err != nil {
+		return nil, err
+	}
+
+	var afterSeq *int64
+	if after != nil {
+		seq, err := decodeCommentCursor(*after)
+		if err != nil {
+			return nil, err
+		}
+		afterSeq = &seq
+	}
+
+	comments, err := r.DataBase.GetComments(ctx, postID, parentID, limit+1, afterSeq)
+	if err != nil {
+		return nil, err
+	}
+
+	connection := &model.CommentConnection{
+		Edges: make([]*model.CommentEdge, 0, limit),
+		PageInfo: &model.PageInfo{
+			HasNextPage:     len(comments) > limit,
+			HasPreviousPage: after != nil,
+		},
+	}
+	if len(comments) > limit {
+		comments = comments[:limit]
+	}
+
+	for _, comment := range comments {
+		connection.Edges = append(connection.Edges, &model.CommentEdge{
+			Cursor: encodeCommentCursor(comment.Seq),
+			Node:   comment,
+		})
+	}
+	if len(connection.Edges) > 0 {
+		connection.PageInfo.StartCursor = &connection.Edges[0].Cursor
+		connection.PageInfo.EndCursor = &connection.Edges[len(connection.Edges)-1].Cursor
+	}
+
+	return connection, nil
+}
+
+// postConnection loads one page of posts. One extra post is requested to fill hasNextPage.
+func (r *Resolver) postConnection(ctx context.Context, first *int, after *string, orderBy *model.PostOrder, filter *model.PostFilter) (*model.PostConnection, error) {
+	limit, err := pageSize(first)
+	if err != nil {
+		return nil, err
+	}
+
+	query := db.PostQuery{OrderBy: model.PostOrderNewest, First: limit + 1}
+	if orderBy != nil {
+		query.OrderBy = *orderBy
+	}
+	if after != nil {
+		query.After, err = decodePostCursor(query.OrderBy, *after)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if filter != nil {
+		query.AuthorID = filter.AuthorID
+		query.AllowComments = filter.AllowComments
+		query.CreatedAfter = filter.CreatedAfter
+	}
+
+	posts, err := r.DataBase.GetPosts(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	connection := &model.PostConnection{
+		Edges: make([]*model.PostEdge, 0, limit),
+		PageInfo: &model.PageInfo{
+			HasNextPage:     len(posts) > limit,
+			HasPreviousPage: after != nil,
+		},
+	}
+	if len(posts) > limit {
+		posts = posts[:limit]
+	}
+
+	for _, post := range posts {
+		connection.Edges = append(connection.Edges, &model.PostEdge{
+			Cursor: encodePostCursor(query.OrderBy, post),
+			Node:   post,
+		})
+	}
+	if len(connection.Edges) > 0 {
+		connection.PageInfo.StartCursor = &connection.Edges[0].Cursor
+		connection.PageInfo.EndCursor = &connection.Edges[len(connection.Edges)-1].Cursor
+	}
+
+	return connection, nil
+}
@@ -0,0 +1,31 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"postsandcomments/internal/graph/model"
+)
+
+func (r *postResolver) Comments(ctx context.Context, obj *model.Post, first *int, after *string) (*model.CommentConnection, error) {
+	connection, err := r.commentConnection(ctx, obj.ID, nil, first, after)
+	if err != nil {
+		r.Logger.Errorf("error to get comments of post: %v", err)
+		return nil, fmt.Errorf("error to get comments of post: %w", err)
+	}
+
+	return connection, nil
+}
+
+func (r *postResolver) Author(ctx context.Context, obj *model.Post) (*model.User, error) {
+	if obj.AuthorID == nil {
+		return nil, nil
+	}
+
+	user, err := r.userByID(ctx, *obj.AuthorID)
+	if err != nil {
+		r.Logger.Errorf("error to get author of post: %v", err)
+		return nil, fmt.Errorf("error to get author of post: %w", err)
+	}
+
+	return user, nil
+}
@@ -6,22 +6,22 @@ import (
 	"postsandcomments/internal/graph/model"
 )
 
-func (r *queryResolver) Posts(ctx context.Context) ([]*model.Post, error) {
-	posts, err := r.DataBase.GetPosts(ctx)
+func (r *queryResolver) Posts(ctx context.Context, first *int, after *string, orderBy *model.PostOrder, filter *model.PostFilter) (*model.PostConnection, error) {
+	connection, err := r.postConnection(ctx, first, after, orderBy, filter)
 	if err != nil {
-		r.Logger.Errorf("error to get all posts: %v", err)
-		return nil, fmt.Errorf("error to get all posts: %v", err)
+		r.Logger.Errorf("error to get posts: %v", err)
+		return nil, fmt.Errorf("error to get posts: %w", err)
 	}
 
-	r.Logger.Infof("get all posts")
-	return posts, nil
+	r.Logger.Infof("get %d posts", len(connection.Edges))
+	return connection, nil
 }
 
-func (r *queryResolver) Post(ctx context.Context, id string, limit *int, offset *int) (*model.Post, error) {
-	post, err := r.DataBase.GetPostById(ctx, id, limit, offset)
+func (r *queryResolver) Post(ctx context.Context, id string) (*model.Post, error) {
+	post, err := r.DataBase.GetPostById(ctx, id)
 	if err != nil {
 		r.Logger.Errorf("error to get post by id: %v", err)
-		return nil, fmt.Errorf("error to get post by id: %v", err)
+		return nil, fmt.Errorf("error to get post by id: %w", err)
 	}
 
 	r.Logger.Infof("get post with id = %s", id)
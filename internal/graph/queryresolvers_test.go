@@ -0,0 +1,59 @@
+package graph_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/graph/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nodeIDs(connection *model.PostConnection) []string {
+	ids := make([]string, 0, len(connection.Edges))
+	for _, edge := range connection.Edges {
+		ids = append(ids, edge.Node.ID)
+	}
+	return ids
+}
+
+func TestPostsPagination(t *testing.T) {
+	resolver := newResolver(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	ids := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		post := &model.Post{ID: uuid.New().String(), Title: "Post", Body: "Body", CreatedAt: now.Add(time.Duration(i) * time.Hour)}
+		require.NoError(t, resolver.DataBase.CreatePost(ctx, post))
+		ids = append(ids, post.ID)
+	}
+
+	first, newest := 2, model.PostOrderNewest
+	page, err := resolver.Query().Posts(ctx, &first, nil, &newest, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ids[2], ids[1]}, nodeIDs(page))
+	assert.True(t, page.PageInfo.HasNextPage)
+	assert.False(t, page.PageInfo.HasPreviousPage)
+
+	page, err = resolver.Query().Posts(ctx, &first, page.PageInfo.EndCursor, &newest, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ids[0]}, nodeIDs(page))
+	assert.False(t, page.PageInfo.HasNextPage)
+	assert.True(t, page.PageInfo.HasPreviousPage)
+
+	// A cursor of one order is rejected by another.
+	oldest := model.PostOrderOldest
+	_, err = resolver.Query().Posts(ctx, &first, page.PageInfo.EndCursor, &oldest, nil)
+	var graphErr *graph.Error
+	require.ErrorAs(t, err, &graphErr)
+	assert.Equal(t, graph.ValidationFailed, graphErr.Code)
+
+	createdAfter := now.Add(30 * time.Minute)
+	page, err = resolver.Query().Posts(ctx, nil, nil, &oldest, &model.PostFilter{CreatedAfter: &createdAfter})
+	require.NoError(t, err)
+	assert.Equal(t, []string{ids[1], ids[2]}, nodeIDs(page))
+}
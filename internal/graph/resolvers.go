@@ -1,15 +1,41 @@
 package graph
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"postsandcomments/internal/auth"
 	"postsandcomments/internal/db"
+	"postsandcomments/internal/graph/model"
+	"postsandcomments/internal/ratelimit"
+	"postsandcomments/internal/webhook"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/vektah/gqlparser/v2/gqlerror"
 )
 
+// RateLimited is the error code of requests over a rate limit.
+const RateLimited = "RATE_LIMITED"
+
 type Resolver struct {
 	DataBase            db.Database
 	SubscriptionManager *SubscriptionManager
-	Logger              *logrus.Logger
+	// Webhooks delivers new posts and comments to webhooks, it may be nil.
+	Webhooks *webhook.Dispatcher
+	// RateLimits limits creating posts and comments and opening subscriptions, it may be nil.
+	RateLimits *ratelimit.Policy
+	// Now is the clock of created and edited posts and comments, time.Now if nil.
+	Now    func() time.Time
+	Logger *logrus.Logger
+}
+
+type postResolver struct {
+	*Resolver
+}
+
+type commentResolver struct {
+	*Resolver
 }
 
 type mutationResolver struct {
@@ -24,6 +50,16 @@ type subscriptionResolver struct {
 	*Resolver
 }
 
+// Post returns PostResolver implementation.
+func (r *Resolver) Post() PostResolver {
+	return &postResolver{r}
+}
+
+// Comment returns CommentResolver implementation.
+func (r *Resolver) Comment() CommentResolver {
+	return &commentResolver{r}
+}
+
 // Mutation returns MutationResolver implementation.
 func (r *Resolver) Mutation() MutationResolver {
 	return &mutationResolver{r}
@@ -38,3 +74,52 @@ func (r *Resolver) Query() QueryResolver {
 func (r *Resolver) Subscription() SubscriptionResolver {
 	return &subscriptionResolver{r}
 }
+
+// saveAuthor stores the user making the request and returns its id,
+// or nil if the request is anonymous.
+func (r *Resolver) saveAuthor(ctx context.Context) (*string, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, nil
+	}
+
+	err := r.DataBase.SaveUser(ctx, &model.User{ID: user.ID, Name: user.Name})
+	if err != nil {
+		return nil, fmt.Errorf("error to save author: %v", err)
+	}
+
+	return &user.ID, nil
+}
+
+// allow checks the rate limit of the operation. A denied request gets a
+// RATE_LIMITED error with the seconds to wait in retryAfter. Requests are
+// allowed if the limiter fails, so an unavailable store does not stop writes.
+func (r *Resolver) allow(ctx context.Context, operation string) error {
+	err := r.RateLimits.Allow(ctx, operation)
+	var limited *ratelimit.Error
+	if errors.As(err, &limited) {
+		r.Logger.Warnf("request denied: %v", err)
+		return &gqlerror.Error{
+			Err:     err,
+			Message: err.Error(),
+			Extensions: map[string]interface{}{
+				"code":       RateLimited,
+				"retryAfter": limited.RetryAfterSeconds(),
+			},
+		}
+	}
+	if err != nil {
+		r.Logger.Errorf("%v, request allowed", err)
+	}
+	return nil
+}
+
+// now returns the time of the clock in UTC. Postgres keeps only microseconds,
+// so the time is truncated to be the same in every backend.
+func (r *Resolver) now() time.Time {
+	now := time.Now
+	if r.Now != nil {
+		now = r.Now
+	}
+	return now().UTC().Truncate(time.Microsecond)
+}
@@ -0,0 +1,320 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"postsandcomments/internal/eventbus"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OverflowPolicy decides what happens to an event published to a subscriber
+// whose queue is full.
+type OverflowPolicy string
+
+const (
+	// DropOldest removes the oldest queued event to make room for the new one.
+	DropOldest OverflowPolicy = "drop_oldest"
+	// DropNewest discards the new event.
+	DropNewest OverflowPolicy = "drop_newest"
+	// Disconnect ends the subscription of a client that can't keep up.
+	Disconnect OverflowPolicy = "disconnect"
+)
+
+const DefaultSubscriberQueueSize = 64
+
+type SubscriptionConfig struct {
+	// QueueSize is how many events wait for a slow subscriber before Policy applies.
+	QueueSize int
+	Policy    OverflowPolicy
+	// Bus delivers published events to the managers of all instances,
+	// a LocalBus is used if it is nil.
+	Bus    eventbus.Bus
+	Logger *logrus.Logger
+}
+
+// SubscriptionStats are counters since the manager was created.
+type SubscriptionStats struct {
+	Subscribers  int    `json:"subscribers"`
+	Published    uint64 `json:"published"`
+	Delivered    uint64 `json:"delivered"`
+	Dropped      uint64 `json:"dropped"`
+	Disconnected uint64 `json:"disconnected"`
+}
+
+// TopicSubscribers is how many clients are subscribed to events of one type
+// about one post, or about all posts if PostID is empty.
+type TopicSubscribers struct {
+	EventType   eventbus.EventType
+	PostID      string
+	Subscribers int
+}
+
+// SubscriptionManager fans events from the bus out to subscribers. It never
+// waits for them: every subscriber has its own bounded queue drained by its own
+// goroutine, so a slow client only affects itself.
+type SubscriptionManager struct {
+	config      SubscriptionConfig
+	mutex       sync.RWMutex
+	subscribers map[topic][]*subscriber
+	closed      bool
+
+	published    atomic.Uint64
+	delivered    atomic.Uint64
+	dropped      atomic.Uint64
+	disconnected atomic.Uint64
+}
+
+// topic is what a subscriber listens to: events of one type about one post,
+// or about all posts if postID is empty.
+type topic struct {
+	eventType eventbus.EventType
+	postID    string
+}
+
+type subscriber struct {
+	topic topic
+	out   chan *eventbus.Event
+
+	mutex   sync.Mutex
+	queue   []*eventbus.Event
+	dropped uint64
+	closed  bool
+	// notify wakes up the delivering goroutine when queue is not empty.
+	notify chan struct{}
+	done   chan struct{}
+}
+
+func NewSubscriptionManager(config SubscriptionConfig) (*SubscriptionManager, error) {
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultSubscriberQueueSize
+	}
+	switch config.Policy {
+	case "":
+		config.Policy = DropOldest
+	case DropOldest, DropNewest, Disconnect:
+	default:
+		return nil, fmt.Errorf("unknown subscription overflow policy: %s", config.Policy)
+	}
+	if config.Logger == nil {
+		config.Logger = logrus.StandardLogger()
+	}
+	if config.Bus == nil {
+		config.Bus = eventbus.NewLocalBus()
+	}
+
+	m := &SubscriptionManager{
+		config:      config,
+		subscribers: make(map[topic][]*subscriber),
+		mutex:       sync.RWMutex{},
+	}
+	config.Bus.Subscribe(m.dispatch)
+
+	return m, nil
+}
+
+// Subscribe returns a channel with the events of the type about the post, an
+// empty postID subscribes to events about all posts. The channel is closed when
+// ctx is done, when the client is disconnected for falling behind or when the
+// manager is closed.
+func (m *SubscriptionManager) Subscribe(ctx context.Context, eventType eventbus.EventType, postID string) <-chan *eventbus.Event {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closed {
+		out := make(chan *eventbus.Event)
+		close(out)
+		return out
+	}
+
+	sub := &subscriber{
+		topic:  topic{eventType: eventType, postID: postID},
+		out:    make(chan *eventbus.Event),
+		queue:  make([]*eventbus.Event, 0, m.config.QueueSize),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	m.subscribers[sub.topic] = append(m.subscribers[sub.topic], sub)
+	go m.deliver(sub)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.mutex.Lock()
+			m.remove(sub)
+			m.mutex.Unlock()
+		case <-sub.done:
+		}
+	}()
+
+	return sub.out
+}
+
+// Publish sends the event to the subscribers of every instance. The change is
+// already saved, so the event goes out even if ctx is canceled.
+func (m *SubscriptionManager) Publish(ctx context.Context, event *eventbus.Event) {
+	if err := m.config.Bus.Publish(context.WithoutCancel(ctx), event); err != nil {
+		m.config.Logger.Errorf("error to publish event for post with id = %s: %v", event.PostID, err)
+	}
+}
+
+func (m *SubscriptionManager) dispatch(event *eventbus.Event) {
+	m.published.Add(1)
+
+	eventTopic := topic{eventType: event.Type, postID: event.PostID}
+	if event.Type == eventbus.PostAdded {
+		eventTopic.postID = ""
+	}
+
+	m.mutex.RLock()
+	subscribers := m.subscribers[eventTopic]
+	m.mutex.RUnlock()
+
+	for _, sub := range subscribers {
+		if !m.enqueue(sub, event) {
+			m.disconnect(sub)
+		}
+	}
+}
+
+// Close ends all subscriptions, the events still queued for them are dropped.
+// Later subscriptions end right away. The bus is left open.
+func (m *SubscriptionManager) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.closed = true
+	for _, subscribers := range m.subscribers {
+		for _, sub := range subscribers {
+			m.remove(sub)
+		}
+	}
+}
+
+func (m *SubscriptionManager) Stats() SubscriptionStats {
+	m.mutex.RLock()
+	subscribers := 0
+	for _, subs := range m.subscribers {
+		subscribers += len(subs)
+	}
+	m.mutex.RUnlock()
+
+	return SubscriptionStats{
+		Subscribers:  subscribers,
+		Published:    m.published.Load(),
+		Delivered:    m.delivered.Load(),
+		Dropped:      m.dropped.Load(),
+		Disconnected: m.disconnected.Load(),
+	}
+}
+
+func (m *SubscriptionManager) Topics() []TopicSubscribers {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	topics := make([]TopicSubscribers, 0, len(m.subscribers))
+	for t, subs := range m.subscribers {
+		topics = append(topics, TopicSubscribers{EventType: t.eventType, PostID: t.postID, Subscribers: len(subs)})
+	}
+	return topics
+}
+
+// enqueue applies the overflow policy and returns false if the subscriber has
+// to be disconnected.
+func (m *SubscriptionManager) enqueue(sub *subscriber, event *eventbus.Event) bool {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	if sub.closed {
+		return true
+	}
+
+	if len(sub.queue) == m.config.QueueSize {
+		sub.dropped++
+		m.dropped.Add(1)
+		switch m.config.Policy {
+		case DropNewest:
+			return true
+		case Disconnect:
+			return false
+		default:
+			sub.queue = append(sub.queue[:0], sub.queue[1:]...)
+		}
+	}
+	sub.queue = append(sub.queue, event)
+
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (m *SubscriptionManager) disconnect(sub *subscriber) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.remove(sub) {
+		m.disconnected.Add(1)
+		m.config.Logger.Warnf("disconnected %s subscriber to post with id = %s: %d events dropped", sub.topic.eventType, sub.topic.postID, sub.dropped)
+	}
+}
+
+// remove must be called with the manager locked. It reports whether the
+// subscriber was still subscribed.
+func (m *SubscriptionManager) remove(sub *subscriber) bool {
+	subscribers := m.subscribers[sub.topic]
+	for i := range subscribers {
+		if subscribers[i] == sub {
+			subscribers = append(subscribers[:i:i], subscribers[i+1:]...)
+			if len(subscribers) == 0 {
+				delete(m.subscribers, sub.topic)
+			} else {
+				m.subscribers[sub.topic] = subscribers
+			}
+
+			sub.mutex.Lock()
+			sub.closed = true
+			sub.queue = nil
+			sub.mutex.Unlock()
+			close(sub.done)
+			return true
+		}
+	}
+	return false
+}
+
+// deliver moves events from the queue of the subscriber to its channel one by
+// one and closes the channel once the subscriber is removed.
+func (m *SubscriptionManager) deliver(sub *subscriber) {
+	defer close(sub.out)
+
+	for {
+		sub.mutex.Lock()
+		var event *eventbus.Event
+		if len(sub.queue) > 0 {
+			event = sub.queue[0]
+			sub.queue = sub.queue[1:]
+		}
+		sub.mutex.Unlock()
+
+		if event == nil {
+			select {
+			case <-sub.notify:
+				continue
+			case <-sub.done:
+				return
+			}
+		}
+
+		select {
+		case sub.out <- event:
+			m.delivered.Add(1)
+		case <-sub.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package graph_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/eventbus"
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/graph/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPostID = "test_post_id"
+
+func newManager(t *testing.T, policy graph.OverflowPolicy, queueSize int) *graph.SubscriptionManager {
+	manager, err := graph.NewSubscriptionManager(graph.SubscriptionConfig{QueueSize: queueSize, Policy: policy})
+	require.NoError(t, err)
+	return manager
+}
+
+// publish sends count events numbered from 0 and fails the test if Publish blocks.
+func publish(t *testing.T, manager *graph.SubscriptionManager, count int) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < count; i++ {
+			manager.Publish(context.Background(), &eventbus.Event{
+				Type:    eventbus.CommentAdded,
+				PostID:  testPostID,
+				Comment: &model.Comment{ID: strconv.Itoa(i), PostID: testPostID},
+			})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish is blocked by a subscriber that does not read")
+	}
+}
+
+// receive reads events until the channel is closed or stays empty for a while.
+func receive(ch <-chan *eventbus.Event) (ids []int, closed bool) {
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return ids, true
+			}
+			id, _ := strconv.Atoi(event.Comment.ID)
+			ids = append(ids, id)
+		case <-time.After(100 * time.Millisecond):
+			return ids, false
+		}
+	}
+}
+
+func TestNewSubscriptionManagerWithInvalidPolicy(t *testing.T) {
+	_, err := graph.NewSubscriptionManager(graph.SubscriptionConfig{Policy: "block"})
+	assert.Error(t, err)
+}
+
+func TestPublishDeliversInOrder(t *testing.T) {
+	manager := newManager(t, graph.DropOldest, 16)
+	ch := manager.Subscribe(context.Background(), eventbus.CommentAdded, testPostID)
+	other := manager.Subscribe(context.Background(), eventbus.CommentAdded, "another_post_id")
+
+	publish(t, manager, 10)
+
+	ids, closed := receive(ch)
+	assert.False(t, closed)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, ids)
+
+	ids, _ = receive(other)
+	assert.Empty(t, ids)
+
+	stats := manager.Stats()
+	assert.Equal(t, 2, stats.Subscribers)
+	assert.Equal(t, uint64(10), stats.Published)
+	assert.Equal(t, uint64(10), stats.Delivered)
+	assert.Zero(t, stats.Dropped)
+}
+
+func TestPublishDropOldest(t *testing.T) {
+	manager := newManager(t, graph.DropOldest, 4)
+	ch := manager.Subscribe(context.Background(), eventbus.CommentAdded, testPostID)
+
+	publish(t, manager, 100)
+
+	// Besides the queue, one more event may already be taken from it and wait
+	// to be read. The queue itself keeps the newest events.
+	ids, closed := receive(ch)
+	assert.False(t, closed)
+	require.GreaterOrEqual(t, len(ids), 4)
+	require.LessOrEqual(t, len(ids), 5)
+	assert.IsIncreasing(t, ids)
+	assert.Equal(t, []int{96, 97, 98, 99}, ids[len(ids)-4:])
+	assert.Equal(t, uint64(100-len(ids)), manager.Stats().Dropped)
+}
+
+func TestPublishDropNewest(t *testing.T) {
+	manager := newManager(t, graph.DropNewest, 4)
+	ch := manager.Subscribe(context.Background(), eventbus.CommentAdded, testPostID)
+
+	publish(t, manager, 100)
+
+	ids, closed := receive(ch)
+	assert.False(t, closed)
+	require.GreaterOrEqual(t, len(ids), 4)
+	require.LessOrEqual(t, len(ids), 5)
+	assert.IsIncreasing(t, ids)
+	assert.Equal(t, 0, ids[0])
+	assert.NotContains(t, ids, 99)
+	assert.Equal(t, uint64(100-len(ids)), manager.Stats().Dropped)
+}
+
+func TestPublishDisconnect(t *testing.T) {
+	manager := newManager(t, graph.Disconnect, 4)
+	slow := manager.Subscribe(context.Background(), eventbus.CommentAdded, testPostID)
+
+	publish(t, manager, 100)
+
+	ids, closed := receive(slow)
+	assert.True(t, closed)
+	assert.LessOrEqual(t, len(ids), 5)
+
+	stats := manager.Stats()
+	assert.Equal(t, 0, stats.Subscribers)
+	assert.Equal(t, uint64(1), stats.Disconnected)
+
+	// The post can be subscribed to again after the slow client is gone.
+	ch := manager.Subscribe(context.Background(), eventbus.CommentAdded, testPostID)
+	publish(t, manager, 1)
+	ids, _ = receive(ch)
+	assert.Equal(t, []int{0}, ids)
+}
+
+func TestCanceledSubscriptionClosesChannel(t *testing.T) {
+	manager := newManager(t, graph.DropOldest, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := manager.Subscribe(ctx, eventbus.CommentAdded, testPostID)
+
+	publish(t, manager, 10)
+	cancel()
+
+	_, closed := receive(ch)
+	assert.True(t, closed)
+	assert.Equal(t, 0, manager.Stats().Subscribers)
+
+	publish(t, manager, 10)
+}
+
+func TestCloseEndsSubscriptions(t *testing.T) {
+	manager := newManager(t, graph.DropOldest, 4)
+	ctx := context.Background()
+	comments := manager.Subscribe(ctx, eventbus.CommentAdded, testPostID)
+	posts := manager.Subscribe(ctx, eventbus.PostAdded, "")
+
+	manager.Close()
+
+	_, closed := receive(comments)
+	assert.True(t, closed)
+	_, closed = receive(posts)
+	assert.True(t, closed)
+	assert.Equal(t, 0, manager.Stats().Subscribers)
+
+	_, closed = receive(manager.Subscribe(ctx, eventbus.CommentAdded, testPostID))
+	assert.True(t, closed)
+	assert.Equal(t, 0, manager.Stats().Subscribers)
+	publish(t, manager, 10)
+}
+
+func TestConcurrentPublishAndUnsubscribe(t *testing.T) {
+	manager := newManager(t, graph.DropOldest, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			publish(t, manager, 200)
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				ch := manager.Subscribe(ctx, eventbus.CommentAdded, testPostID)
+				cancel()
+				for range ch {
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 0, manager.Stats().Subscribers)
+}
+
+func TestPublishRoutesEventsByType(t *testing.T) {
+	manager := newManager(t, graph.DropOldest, 16)
+	ctx := context.Background()
+	postAdded := manager.Subscribe(ctx, eventbus.PostAdded, "")
+	commentUpdated := manager.Subscribe(ctx, eventbus.CommentUpdated, testPostID)
+	commentDeleted := manager.Subscribe(ctx, eventbus.CommentDeleted, testPostID)
+
+	events := []*eventbus.Event{
+		{Type: eventbus.PostAdded, PostID: "new_post_id", Post: &model.Post{ID: "new_post_id"}},
+		{Type: eventbus.CommentAdded, PostID: testPostID, Comment: &model.Comment{ID: "1"}},
+		{Type: eventbus.CommentUpdated, PostID: testPostID, Comment: &model.Comment{ID: "2"}},
+		{Type: eventbus.CommentUpdated, PostID: "another_post_id", Comment: &model.Comment{ID: "3"}},
+		{Type: eventbus.CommentDeleted, PostID: testPostID, Comment: &model.Comment{ID: "4"}},
+	}
+	for _, event := range events {
+		manager.Publish(ctx, event)
+	}
+
+	select {
+	case event := <-postAdded:
+		assert.Equal(t, "new_post_id", event.Post.ID)
+	case <-time.After(time.Second):
+		t.Fatal("postAdded event is not delivered")
+	}
+	ids, _ := receive(commentUpdated)
+	assert.Equal(t, []int{2}, ids)
+	ids, _ = receive(commentDeleted)
+	assert.Equal(t, []int{4}, ids)
+}
@@ -2,68 +2,155 @@ package graph
 
 import (
 	"context"
+	"fmt"
+	"postsandcomments/internal/eventbus"
 	"postsandcomments/internal/graph/model"
-	"sync"
+	"postsandcomments/internal/ratelimit"
+	"strconv"
 )
 
-type CommentEvent struct {
-	PostID  string
-	Comment *model.Comment
-}
+// MaxReplayedComments limits how many missed comments a resumed commentAdded
+// subscription may replay, a client that missed more has to reload the post.
+const MaxReplayedComments = 1000
 
-type SubscriptionManager struct {
-	mutex       sync.RWMutex
-	subscribers map[string][]chan *model.Comment
-}
+// CommentAdded resumes after afterEventId, if it is set, by replaying the comments
+// created since then from storage before the live ones. Comment.eventId is the
+// Seq of the comment, so it is the same on every instance and after restarts.
+func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID string, afterEventID *string) (<-chan *model.Comment, error) {
+	if err := r.allow(ctx, ratelimit.Subscription); err != nil {
+		return nil, err
+	}
 
-func NewSubscriptionManager() *SubscriptionManager {
-	return &SubscriptionManager{
-		subscribers: make(map[string][]chan *model.Comment),
-		mutex:       sync.RWMutex{},
+	if afterEventID == nil {
+		events := r.SubscriptionManager.Subscribe(ctx, eventbus.CommentAdded, postID)
+
+		r.Logger.Infof("added client to %s subscribers for post with id = %s", eventbus.CommentAdded, postID)
+		return forward(ctx, events, func(event *eventbus.Event) *model.Comment {
+			return event.Comment
+		}), nil
 	}
-}
 
-func (m *SubscriptionManager) Subscribe(postID string) <-chan *model.Comment {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	after, err := strconv.ParseInt(*afterEventID, 10, 64)
+	if err != nil || after < 0 {
+		r.Logger.Errorf("error to resume subscription: invalid afterEventId %q", *afterEventID)
+		return nil, newError(ValidationFailed, "error to resume subscription: invalid afterEventId")
+	}
 
-	ch := make(chan *model.Comment, 1)
-	m.subscribers[postID] = append(m.subscribers[postID], ch)
-	return ch
-}
+	// Subscribing before reading storage makes sure nothing created in between
+	// is lost. Live events are sent to the channel in the order they are
+	// published, which is not always the order of Seq, so they are only used to
+	// notice new comments, and the comments themselves are read from storage
+	// after the last sent one. Storage returns comments of a post in the order
+	// they were committed, so the highest eventId a client got is always a safe
+	// place to resume from.
+	ctx, cancel := context.WithCancel(ctx)
+	events := r.SubscriptionManager.Subscribe(ctx, eventbus.CommentAdded, postID)
 
-func (m *SubscriptionManager) Unsubscribe(postID string, ch <-chan *model.Comment) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	missed, err := r.DataBase.GetCommentsSince(ctx, postID, after, MaxReplayedComments+1)
+	if err != nil {
+		cancel()
+		r.Logger.Errorf("error to resume subscription: %v", err)
+		return nil, fmt.Errorf("error to resume subscription: %w", err)
+	}
+	if len(missed) > MaxReplayedComments {
+		cancel()
+		r.Logger.Errorf("error to resume subscription: more than %d comments missed", MaxReplayedComments)
+		return nil, newError(ValidationFailed, "error to resume subscription: too many comments missed, reload the post")
+	}
+
+	ch := make(chan *model.Comment)
+	go func() {
+		defer cancel()
+		defer close(ch)
 
-	subscribers := m.subscribers[postID]
-	for i := range subscribers {
-		if subscribers[i] == ch {
-			subscribers = append(subscribers[:i], subscribers[i+1:]...)
-			break
+		last := after
+		send := func(comments []*model.Comment) bool {
+			for _, comment := range comments {
+				select {
+				case ch <- comment:
+					last = comment.Seq
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
 		}
-	}
-	m.subscribers[postID] = subscribers
+
+		if !send(missed) {
+			return
+		}
+		for event := range events {
+			// Comments up to last were committed before it, so they are sent already.
+			if event.Comment.Seq <= last {
+				continue
+			}
+			comments, err := r.DataBase.GetCommentsSince(ctx, postID, last, MaxReplayedComments)
+			if err != nil {
+				r.Logger.Errorf("error to get comments of resumed subscription: %v", err)
+				return
+			}
+			if !send(comments) {
+				return
+			}
+		}
+	}()
+
+	r.Logger.Infof("resumed %s subscription for post with id = %s after event %d, %d comments replayed", eventbus.CommentAdded, postID, after, len(missed))
+	return ch, nil
 }
 
-func (m *SubscriptionManager) Publish(event *CommentEvent) {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+func (r *subscriptionResolver) PostAdded(ctx context.Context) (<-chan *model.Post, error) {
+	if err := r.allow(ctx, ratelimit.Subscription); err != nil {
+		return nil, err
+	}
+
+	events := r.SubscriptionManager.Subscribe(ctx, eventbus.PostAdded, "")
 
-	subscribers := m.subscribers[event.PostID]
-	for _, ch := range subscribers {
-		ch <- event.Comment
+	r.Logger.Infof("added client to %s subscribers", eventbus.PostAdded)
+	return forward(ctx, events, func(event *eventbus.Event) *model.Post {
+		return event.Post
+	}), nil
+}
+
+func (r *subscriptionResolver) CommentUpdated(ctx context.Context, postID string) (<-chan *model.Comment, error) {
+	if err := r.allow(ctx, ratelimit.Subscription); err != nil {
+		return nil, err
 	}
+
+	events := r.SubscriptionManager.Subscribe(ctx, eventbus.CommentUpdated, postID)
+
+	r.Logger.Infof("added client to %s subscribers for post with id = %s", eventbus.CommentUpdated, postID)
+	return forward(ctx, events, func(event *eventbus.Event) *model.Comment {
+		return event.Comment
+	}), nil
 }
 
-func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID string) (<-chan *model.Comment, error) {
-	ch := r.SubscriptionManager.Subscribe(postID)
+func (r *subscriptionResolver) CommentDeleted(ctx context.Context, postID string) (<-chan string, error) {
+	if err := r.allow(ctx, ratelimit.Subscription); err != nil {
+		return nil, err
+	}
+
+	events := r.SubscriptionManager.Subscribe(ctx, eventbus.CommentDeleted, postID)
+
+	r.Logger.Infof("added client to %s subscribers for post with id = %s", eventbus.CommentDeleted, postID)
+	return forward(ctx, events, func(event *eventbus.Event) string {
+		return event.Comment.ID
+	}), nil
+}
 
+// forward converts events into the values a subscription returns. The result
+// is closed together with events.
+func forward[T any](ctx context.Context, events <-chan *eventbus.Event, convert func(event *eventbus.Event) T) <-chan T {
+	ch := make(chan T)
 	go func() {
-		<-ctx.Done()
-		r.SubscriptionManager.Unsubscribe(postID, ch)
+		defer close(ch)
+		for event := range events {
+			select {
+			case ch <- convert(event):
+			case <-ctx.Done():
+				return
+			}
+		}
 	}()
-
-	r.Logger.Infof("added client to subscribers for post with id = %s", postID)
-	return ch, nil
+	return ch
 }
@@ -0,0 +1,158 @@
+package graph_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/eventbus"
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/graph/model"
+	"postsandcomments/internal/ratelimit"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+func newResolver(t *testing.T) *graph.Resolver {
+	manager, err := graph.NewSubscriptionManager(graph.SubscriptionConfig{})
+	require.NoError(t, err)
+
+	return &graph.Resolver{
+		DataBase:            db.NewInMemoryDB(),
+		SubscriptionManager: manager,
+		Logger:              logrus.New(),
+	}
+}
+
+func receiveComments(t *testing.T, ch <-chan *model.Comment, count int) []string {
+	ids := make([]string, 0, count)
+	for len(ids) < count {
+		select {
+		case comment := <-ch:
+			ids = append(ids, comment.ID)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d comments", len(ids), count)
+		}
+	}
+
+	select {
+	case comment := <-ch:
+		t.Fatalf("unexpected comment %s", comment.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+	return ids
+}
+
+func TestResumeCommentAdded(t *testing.T) {
+	resolver := newResolver(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	post, err := resolver.Mutation().CreatePost(ctx, "Test Post", "Test body", true)
+	require.NoError(t, err)
+
+	seen, err := resolver.Mutation().CreateComment(ctx, post.ID, "Seen", nil)
+	require.NoError(t, err)
+	missed, err := resolver.Mutation().CreateComment(ctx, post.ID, "Missed", nil)
+	require.NoError(t, err)
+	missedReply, err := resolver.Mutation().CreateComment(ctx, post.ID, "Missed reply", &seen.ID)
+	require.NoError(t, err)
+
+	eventID, err := resolver.Comment().EventID(ctx, seen)
+	require.NoError(t, err)
+	ch, err := resolver.Subscription().CommentAdded(ctx, post.ID, &eventID)
+	require.NoError(t, err)
+
+	live, err := resolver.Mutation().CreateComment(ctx, post.ID, "Live", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{missed.ID, missedReply.ID, live.ID}, receiveComments(t, ch, 3))
+}
+
+// TestResumedCommentAddedInSeqOrder publishes events of two comments in the
+// reverse order, as concurrent requests may do.
+func TestResumedCommentAddedInSeqOrder(t *testing.T) {
+	resolver := newResolver(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	post, err := resolver.Mutation().CreatePost(ctx, "Test Post", "Test body", true)
+	require.NoError(t, err)
+	eventID := "0"
+	ch, err := resolver.Subscription().CommentAdded(ctx, post.ID, &eventID)
+	require.NoError(t, err)
+
+	first := &model.Comment{ID: uuid.New().String(), PostID: post.ID, Body: "First"}
+	require.NoError(t, resolver.DataBase.CreateComment(ctx, post, first))
+	second := &model.Comment{ID: uuid.New().String(), PostID: post.ID, Body: "Second"}
+	require.NoError(t, resolver.DataBase.CreateComment(ctx, post, second))
+
+	for _, comment := range []*model.Comment{second, first} {
+		resolver.SubscriptionManager.Publish(ctx, &eventbus.Event{Type: eventbus.CommentAdded, PostID: post.ID, Comment: comment})
+	}
+
+	assert.Equal(t, []string{first.ID, second.ID}, receiveComments(t, ch, 2))
+}
+
+func TestCommentAddedWithoutAfterEventID(t *testing.T) {
+	resolver := newResolver(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	post, err := resolver.Mutation().CreatePost(ctx, "Test Post", "Test body", true)
+	require.NoError(t, err)
+	_, err = resolver.Mutation().CreateComment(ctx, post.ID, "Before", nil)
+	require.NoError(t, err)
+
+	ch, err := resolver.Subscription().CommentAdded(ctx, post.ID, nil)
+	require.NoError(t, err)
+
+	live, err := resolver.Mutation().CreateComment(ctx, post.ID, "Live", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{live.ID}, receiveComments(t, ch, 1))
+}
+
+func TestResumeCommentAddedWithInvalidEventID(t *testing.T) {
+	resolver := newResolver(t)
+
+	invalid := "not-a-number"
+	_, err := resolver.Subscription().CommentAdded(context.Background(), "test_post_id", &invalid)
+	assert.Error(t, err)
+}
+
+func TestRateLimits(t *testing.T) {
+	resolver := newResolver(t)
+	resolver.RateLimits = &ratelimit.Policy{
+		Limiter: ratelimit.NewMemoryLimiter(),
+		Limits: map[string]ratelimit.Limit{
+			ratelimit.CreateComment: {Requests: 1, Per: time.Minute},
+			ratelimit.Subscription:  {Requests: 1, Per: time.Minute},
+		},
+	}
+	ctx, cancel := context.WithCancel(ratelimit.WithClientIP(context.Background(), "192.0.2.1"))
+	defer cancel()
+
+	post, err := resolver.Mutation().CreatePost(ctx, "Test Post", "Test body", true)
+	require.NoError(t, err)
+
+	_, err = resolver.Mutation().CreateComment(ctx, post.ID, "Allowed", nil)
+	require.NoError(t, err)
+	_, err = resolver.Mutation().CreateComment(ctx, post.ID, "Denied", nil)
+	var gqlErr *gqlerror.Error
+	require.ErrorAs(t, err, &gqlErr)
+	assert.Equal(t, graph.RateLimited, gqlErr.Extensions["code"])
+	assert.Equal(t, 60, gqlErr.Extensions["retryAfter"])
+
+	_, err = resolver.Subscription().CommentAdded(ctx, post.ID, nil)
+	require.NoError(t, err)
+	_, err = resolver.Subscription().PostAdded(ctx)
+	var limited *ratelimit.Error
+	require.ErrorAs(t, err, &limited)
+	assert.Equal(t, ratelimit.Subscription, limited.Operation)
+}
@@ -0,0 +1,128 @@
+package graph
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/graph/model"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// Authors of the posts and comments in a response are resolved concurrently,
+// lookups made within userLoaderWait of the first one go to the database as a
+// single GetUsersByIds of up to userLoaderMaxBatch ids.
+const (
+	userLoaderWait     = time.Millisecond
+	userLoaderMaxBatch = 100
+)
+
+type userLoaderKey struct{}
+
+// BatchUserLookups gives every query and mutation a loader of authors, so a
+// page of posts with their comments does not look authors up one by one.
+// Subscriptions are left out: they run for long, and the loader keeps users
+// for the whole operation.
+func BatchUserLookups(database db.Database) graphql.OperationMiddleware {
+	return func(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+		operation := graphql.GetOperationContext(ctx).Operation
+		if operation == nil || operation.Operation == ast.Subscription {
+			return next(ctx)
+		}
+
+		loader := &userLoader{database: database, users: make(map[string]*userResult)}
+		return next(context.WithValue(ctx, userLoaderKey{}, loader))
+	}
+}
+
+type userLoader struct {
+	database db.Database
+
+	mutex   sync.Mutex
+	users   map[string]*userResult
+	pending *userBatch
+}
+
+type userResult struct {
+	done chan struct{}
+	user *model.User
+	err  error
+}
+
+type userBatch struct {
+	ids     []string
+	results []*userResult
+	fetched bool
+}
+
+// userByID takes the user from the loader of the operation if it has one.
+func (r *Resolver) userByID(ctx context.Context, id string) (*model.User, error) {
+	if loader, ok := ctx.Value(userLoaderKey{}).(*userLoader); ok {
+		return loader.load(ctx, id)
+	}
+	return r.DataBase.GetUserById(ctx, id)
+}
+
+func (l *userLoader) load(ctx context.Context, id string) (*model.User, error) {
+	l.mutex.Lock()
+	result, ok := l.users[id]
+	if !ok {
+		result = &userResult{done: make(chan struct{})}
+		l.users[id] = result
+
+		batch := l.pending
+		if batch == nil {
+			batch = &userBatch{}
+			l.pending = batch
+			time.AfterFunc(userLoaderWait, func() { l.fetch(ctx, batch) })
+		}
+		batch.ids = append(batch.ids, id)
+		batch.results = append(batch.results, result)
+		if len(batch.ids) >= userLoaderMaxBatch {
+			go l.fetch(ctx, batch)
+		}
+	}
+	l.mutex.Unlock()
+
+	select {
+	case <-result.done:
+		return result.user, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetch loads the batch once, whichever of the timer and a full batch comes first.
+func (l *userLoader) fetch(ctx context.Context, batch *userBatch) {
+	l.mutex.Lock()
+	if batch.fetched {
+		l.mutex.Unlock()
+		return
+	}
+	batch.fetched = true
+	if l.pending == batch {
+		l.pending = nil
+	}
+	l.mutex.Unlock()
+
+	users, err := l.database.GetUsersByIds(ctx, batch.ids)
+	byID := make(map[string]*model.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+
+	for i, id := range batch.ids {
+		result := batch.results[i]
+		if err != nil {
+			result.err = err
+		} else if user, ok := byID[id]; ok {
+			result.user = user
+		} else {
+			result.err = &db.NotFoundError{Kind: "users", ID: id}
+		}
+		close(result.done)
+	}
+}
@@ -0,0 +1,79 @@
+package graph_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/graph/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingDB counts the users looked up.
+type countingDB struct {
+	db.Database
+
+	mutex       sync.Mutex
+	singleCalls int
+	loadedIDs   []string
+}
+
+func (d *countingDB) GetUserById(ctx context.Context, id string) (*model.User, error) {
+	d.mutex.Lock()
+	d.singleCalls++
+	d.mutex.Unlock()
+	return d.Database.GetUserById(ctx, id)
+}
+
+func (d *countingDB) GetUsersByIds(ctx context.Context, ids []string) ([]*model.User, error) {
+	d.mutex.Lock()
+	d.loadedIDs = append(d.loadedIDs, ids...)
+	d.mutex.Unlock()
+	return d.Database.GetUsersByIds(ctx, ids)
+}
+
+func TestAuthorsAreLoadedInBatches(t *testing.T) {
+	database := &countingDB{Database: db.NewInMemoryDB()}
+	ctx := context.Background()
+	users := []*model.User{{ID: "first_user_id", Name: "First User"}, {ID: "second_user_id", Name: "Second User"}}
+	for _, user := range users {
+		require.NoError(t, database.SaveUser(ctx, user))
+	}
+	for i := 0; i < 5; i++ {
+		post := &model.Post{ID: uuid.New().String(), Title: "Post", Body: "Body", AllowComments: true, AuthorID: &users[i%2].ID}
+		require.NoError(t, database.CreatePost(ctx, post))
+		for j := 0; j < 3; j++ {
+			comment := &model.Comment{ID: uuid.New().String(), PostID: post.ID, Body: "Comment", AuthorID: &users[j%2].ID}
+			require.NoError(t, database.CreateComment(ctx, post, comment))
+		}
+	}
+
+	code, resp := execute(t, database, &graph.Limits{}, `{
+		posts { edges { node { author { name } comments { edges { node { author { name } } } } } } }
+	}`, nil)
+	assert.Equal(t, http.StatusOK, code)
+	require.Empty(t, resp.Errors)
+
+	names := 0
+	for _, edge := range resp.Data["posts"].(map[string]interface{})["edges"].([]interface{}) {
+		node := edge.(map[string]interface{})["node"].(map[string]interface{})
+		assert.NotEmpty(t, node["author"].(map[string]interface{})["name"])
+		names++
+		for _, edge := range node["comments"].(map[string]interface{})["edges"].([]interface{}) {
+			node := edge.(map[string]interface{})["node"].(map[string]interface{})
+			assert.NotEmpty(t, node["author"].(map[string]interface{})["name"])
+			names++
+		}
+	}
+	assert.Equal(t, 20, names)
+
+	// Every author is loaded once for the whole operation.
+	assert.Zero(t, database.singleCalls)
+	assert.ElementsMatch(t, []string{"first_user_id", "second_user_id"}, database.loadedIDs)
+}
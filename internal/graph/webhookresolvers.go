@@ -0,0 +1,125 @@
+package graph
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"postsandcomments/internal/auth"
+	"postsandcomments/internal/graph/model"
+
+	"github.com/google/uuid"
+)
+
+const webhookSecretSize = 32
+
+func (r *mutationResolver) CreateWebhook(ctx context.Context, webhookURL string, events []model.WebhookEvent, postID *string) (*model.CreatedWebhook, error) {
+	if err := requireAdmin(ctx); err != nil {
+		r.Logger.Errorf("error to create webhook: %v", err)
+		return nil, fmt.Errorf("error to create webhook: %w", err)
+	}
+
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		r.Logger.Errorf("error to create webhook: invalid url %q", webhookURL)
+		return nil, newError(ValidationFailed, "error to create webhook: url should be an absolute http or https url")
+	}
+	if len(events) == 0 {
+		r.Logger.Errorf("error to create webhook: no events")
+		return nil, newError(ValidationFailed, "error to create webhook: at least one event is required")
+	}
+
+	if postID != nil {
+		if _, err := r.DataBase.GetPostById(ctx, *postID); err != nil {
+			r.Logger.Errorf("error to get post by id to create webhook: %v", err)
+			return nil, fmt.Errorf("error to get post by id to create webhook: %w", err)
+		}
+	}
+
+	secret := make([]byte, webhookSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		r.Logger.Errorf("error to create webhook: %v", err)
+		return nil, fmt.Errorf("error to create webhook: %w", err)
+	}
+
+	webhook := &model.Webhook{
+		ID:     uuid.New().String(),
+		URL:    webhookURL,
+		Events: events,
+		PostID: postID,
+		Secret: hex.EncodeToString(secret),
+	}
+
+	err = r.DataBase.CreateWebhook(ctx, webhook)
+	if err != nil {
+		r.Logger.Errorf("error to create webhook: %v", err)
+		return nil, fmt.Errorf("error to create webhook: %w", err)
+	}
+
+	r.Logger.Infof("webhook with id = %s created", webhook.ID)
+	return &model.CreatedWebhook{Webhook: webhook, Secret: webhook.Secret}, nil
+}
+
+func (r *mutationResolver) DeleteWebhook(ctx context.Context, id string) (bool, error) {
+	if err := requireAdmin(ctx); err != nil {
+		r.Logger.Errorf("error to delete webhook: %v", err)
+		return false, fmt.Errorf("error to delete webhook: %w", err)
+	}
+
+	err := r.DataBase.DeleteWebhook(ctx, id)
+	if err != nil {
+		r.Logger.Errorf("error to delete webhook: %v", err)
+		return false, fmt.Errorf("error to delete webhook: %w", err)
+	}
+
+	r.Logger.Infof("webhook with id = %s deleted", id)
+	return true, nil
+}
+
+func (r *queryResolver) Webhooks(ctx context.Context) ([]*model.Webhook, error) {
+	if err := requireAdmin(ctx); err != nil {
+		r.Logger.Errorf("error to get webhooks: %v", err)
+		return nil, fmt.Errorf("error to get webhooks: %w", err)
+	}
+
+	webhooks, err := r.DataBase.GetWebhooks(ctx)
+	if err != nil {
+		r.Logger.Errorf("error to get webhooks: %v", err)
+		return nil, fmt.Errorf("error to get webhooks: %w", err)
+	}
+
+	r.Logger.Infof("get all webhooks")
+	return webhooks, nil
+}
+
+// WebhookDeliveries returns the dead letters, deliveries that failed after all attempts.
+func (r *queryResolver) WebhookDeliveries(ctx context.Context, webhookID *string, limit *int) ([]*model.WebhookDelivery, error) {
+	if err := requireAdmin(ctx); err != nil {
+		r.Logger.Errorf("error to get webhook deliveries: %v", err)
+		return nil, fmt.Errorf("error to get webhook deliveries: %w", err)
+	}
+
+	size, err := pageSize(limit)
+	if err != nil {
+		r.Logger.Errorf("error to get webhook deliveries: %v", err)
+		return nil, fmt.Errorf("error to get webhook deliveries: %w", err)
+	}
+
+	deliveries, err := r.DataBase.GetDeadLetters(ctx, webhookID, size)
+	if err != nil {
+		r.Logger.Errorf("error to get webhook deliveries: %v", err)
+		return nil, fmt.Errorf("error to get webhook deliveries: %w", err)
+	}
+
+	r.Logger.Infof("get failed webhook deliveries")
+	return deliveries, nil
+}
+
+// requireAdmin allows only users with auth.AdminRole to manage webhooks.
+func requireAdmin(ctx context.Context) error {
+	if !auth.ForContext(ctx).HasRole(auth.AdminRole) {
+		return newError(Forbidden, "only admins can manage webhooks")
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package graph_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/auth"
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/graph/model"
+	"postsandcomments/internal/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhooksRequireAdmin(t *testing.T) {
+	resolver := newResolver(t)
+	events := []model.WebhookEvent{model.WebhookEventCommentAdded}
+
+	for _, ctx := range []context.Context{
+		context.Background(),
+		auth.WithUser(context.Background(), &auth.User{ID: "test_user_id", Name: "Test User"}),
+	} {
+		_, err := resolver.Mutation().CreateWebhook(ctx, "https://example.com/webhook", events, nil)
+		assert.Error(t, err)
+		_, err = resolver.Query().Webhooks(ctx)
+		assert.Error(t, err)
+		_, err = resolver.Query().WebhookDeliveries(ctx, nil, nil)
+		assert.Error(t, err)
+		_, err = resolver.Mutation().DeleteWebhook(ctx, "test_webhook_id")
+		assert.Error(t, err)
+	}
+
+	admin := auth.WithUser(context.Background(), &auth.User{ID: "test_admin_id", Name: "Test Admin", Roles: []string{auth.AdminRole}})
+	_, err := resolver.Mutation().CreateWebhook(admin, "ftp://example.com/webhook", events, nil)
+	assert.Error(t, err)
+	_, err = resolver.Mutation().CreateWebhook(admin, "https://example.com/webhook", nil, nil)
+	assert.Error(t, err)
+
+	created, err := resolver.Mutation().CreateWebhook(admin, "https://example.com/webhook", events, nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.Secret)
+
+	webhooks, err := resolver.Query().Webhooks(admin)
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Webhook{created.Webhook}, webhooks)
+
+	// The secret is returned only by createWebhook.
+	_, resp := execute(t, resolver.DataBase, &graph.Limits{}, `{ webhooks { id secret } }`, nil)
+	assert.NotEmpty(t, resp.Errors)
+
+	deleted, err := resolver.Mutation().DeleteWebhook(admin, created.Webhook.ID)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+}
+
+func TestCreateCommentDeliversWebhook(t *testing.T) {
+	bodies := make(chan []byte, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	resolver := newResolver(t)
+	resolver.Webhooks = webhook.NewDispatcher(resolver.DataBase, webhook.Config{Logger: resolver.Logger})
+	defer resolver.Webhooks.Close()
+
+	ctx := context.Background()
+	admin := auth.WithUser(ctx, &auth.User{ID: "test_admin_id", Name: "Test Admin", Roles: []string{auth.AdminRole}})
+	_, err := resolver.Mutation().CreateWebhook(admin, srv.URL, []model.WebhookEvent{model.WebhookEventCommentAdded}, nil)
+	require.NoError(t, err)
+
+	post, err := resolver.Mutation().CreatePost(ctx, "Test Post", "Test body", true)
+	require.NoError(t, err)
+	comment, err := resolver.Mutation().CreateComment(ctx, post.ID, "Test Comment", nil)
+	require.NoError(t, err)
+
+	select {
+	case body := <-bodies:
+		var payload webhook.Payload
+		require.NoError(t, json.Unmarshal(body, &payload))
+		assert.Equal(t, model.WebhookEventCommentAdded, payload.Event)
+		require.NotNil(t, payload.Comment)
+		assert.Equal(t, comment.ID, payload.Comment.ID)
+	case <-time.After(time.Second):
+		t.Fatal("comment is not delivered to webhook")
+	}
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/graph/model"
+)
+
+// InstrumentDatabase records the latency and errors of every method of the
+// database under the backend label. Ping and Close are not recorded.
+func (m *Metrics) InstrumentDatabase(backend string, database db.Database) db.Database {
+	return &instrumentedDatabase{Database: database, metrics: m, backend: backend}
+}
+
+type instrumentedDatabase struct {
+	db.Database
+	metrics *Metrics
+	backend string
+}
+
+func (d *instrumentedDatabase) observe(method string, start time.Time, err *error) {
+	d.metrics.dbDuration.WithLabelValues(d.backend, method).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		d.metrics.dbErrors.WithLabelValues(d.backend, method).Inc()
+	}
+}
+
+func (d *instrumentedDatabase) CreatePost(ctx context.Context, post *model.Post) (err error) {
+	defer d.observe("CreatePost", time.Now(), &err)
+	return d.Database.CreatePost(ctx, post)
+}
+
+func (d *instrumentedDatabase) GetPosts(ctx context.Context, query db.PostQuery) (result []*model.Post, err error) {
+	defer d.observe("GetPosts", time.Now(), &err)
+	return d.Database.GetPosts(ctx, query)
+}
+
+func (d *instrumentedDatabase) GetPostById(ctx context.Context, id string) (result *model.Post, err error) {
+	defer d.observe("GetPostById", time.Now(), &err)
+	return d.Database.GetPostById(ctx, id)
+}
+
+func (d *instrumentedDatabase) UpdatePost(ctx context.Context, post *model.Post) (err error) {
+	defer d.observe("UpdatePost", time.Now(), &err)
+	return d.Database.UpdatePost(ctx, post)
+}
+
+func (d *instrumentedDatabase) DeletePost(ctx context.Context, id string) (err error) {
+	defer d.observe("DeletePost", time.Now(), &err)
+	return d.Database.DeletePost(ctx, id)
+}
+
+func (d *instrumentedDatabase) CreateComment(ctx context.Context, post *model.Post, comment *model.Comment) (err error) {
+	defer d.observe("CreateComment", time.Now(), &err)
+	return d.Database.CreateComment(ctx, post, comment)
+}
+
+func (d *instrumentedDatabase) GetCommentById(ctx context.Context, id string) (result *model.Comment, err error) {
+	defer d.observe("GetCommentById", time.Now(), &err)
+	return d.Database.GetCommentById(ctx, id)
+}
+
+func (d *instrumentedDatabase) GetComments(ctx context.Context, postID string, parentID *string, first int, after *int64) (result []*model.Comment, err error) {
+	defer d.observe("GetComments", time.Now(), &err)
+	return d.Database.GetComments(ctx, postID, parentID, first, after)
+}
+
+func (d *instrumentedDatabase) GetCommentsSince(ctx context.Context, postID string, afterSeq int64, limit int) (result []*model.Comment, err error) {
+	defer d.observe("GetCommentsSince", time.Now(), &err)
+	return d.Database.GetCommentsSince(ctx, postID, afterSeq, limit)
+}
+
+func (d *instrumentedDatabase) UpdateComment(ctx context.Context, comment *model.Comment) (err error) {
+	defer d.observe("UpdateComment", time.Now(), &err)
+	return d.Database.UpdateComment(ctx, comment)
+}
+
+func (d *instrumentedDatabase) DeleteComment(ctx context.Context, id string) (err error) {
+	defer d.observe("DeleteComment", time.Now(), &err)
+	return d.Database.DeleteComment(ctx, id)
+}
+
+func (d *instrumentedDatabase) SaveUser(ctx context.Context, user *model.User) (err error) {
+	defer d.observe("SaveUser", time.Now(), &err)
+	return d.Database.SaveUser(ctx, user)
+}
+
+func (d *instrumentedDatabase) GetUserById(ctx context.Context, id string) (result *model.User, err error) {
+	defer d.observe("GetUserById", time.Now(), &err)
+	return d.Database.GetUserById(ctx, id)
+}
+
+func (d *instrumentedDatabase) GetUsersByIds(ctx context.Context, ids []string) (result []*model.User, err error) {
+	defer d.observe("GetUsersByIds", time.Now(), &err)
+	return d.Database.GetUsersByIds(ctx, ids)
+}
+
+func (d *instrumentedDatabase) CreateWebhook(ctx context.Context, webhook *model.Webhook) (err error) {
+	defer d.observe("CreateWebhook", time.Now(), &err)
+	return d.Database.CreateWebhook(ctx, webhook)
+}
+
+func (d *instrumentedDatabase) GetWebhooks(ctx context.Context) (result []*model.Webhook, err error) {
+	defer d.observe("GetWebhooks", time.Now(), &err)
+	return d.Database.GetWebhooks(ctx)
+}
+
+func (d *instrumentedDatabase) DeleteWebhook(ctx context.Context, id string) (err error) {
+	defer d.observe("DeleteWebhook", time.Now(), &err)
+	return d.Database.DeleteWebhook(ctx, id)
+}
+
+func (d *instrumentedDatabase) CreateDeadLetter(ctx context.Context, delivery *model.WebhookDelivery) (err error) {
+	defer d.observe("CreateDeadLetter", time.Now(), &err)
+	return d.Database.CreateDeadLetter(ctx, delivery)
+}
+
+func (d *instrumentedDatabase) GetDeadLetters(ctx context.Context, webhookID *string, limit int) (result []*model.WebhookDelivery, err error) {
+	defer d.observe("GetDeadLetters", time.Now(), &err)
+	return d.Database.GetDeadLetters(ctx, webhookID, limit)
+}
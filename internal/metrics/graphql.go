@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// Tracer is a gqlgen extension that records operations and field resolvers.
+type Tracer struct {
+	metrics *Metrics
+}
+
+var (
+	_ graphql.HandlerExtension    = Tracer{}
+	_ graphql.ResponseInterceptor = Tracer{}
+	_ graphql.FieldInterceptor    = Tracer{}
+)
+
+func (m *Metrics) Tracer() Tracer {
+	return Tracer{metrics: m}
+}
+
+func (Tracer) ExtensionName() string {
+	return "Metrics"
+}
+
+func (Tracer) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+// InterceptResponse records queries and mutations. A subscription responds once
+// per event for as long as it lasts, it is counted by the subscription metrics.
+func (t Tracer) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	oc := graphql.GetOperationContext(ctx)
+	if oc.Operation == nil || oc.Operation.Operation == ast.Subscription {
+		return next(ctx)
+	}
+
+	resp := next(ctx)
+
+	operationType := string(oc.Operation.Operation)
+	operation := oc.OperationName
+	if operation == "" {
+		operation = oc.Operation.Name
+	}
+	operation = t.metrics.operationLabel(operation)
+	status := "ok"
+	if resp == nil || len(resp.Errors) > 0 {
+		status = "error"
+	}
+	t.metrics.operations.WithLabelValues(operationType, operation, status).Inc()
+	t.metrics.operationDuration.WithLabelValues(operationType, operation).
+		Observe(time.Since(oc.Stats.OperationStart).Seconds())
+	return resp
+}
+
+// InterceptField records fields with resolvers, plain struct fields are not worth it.
+func (t Tracer) InterceptField(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || !fc.IsResolver {
+		return next(ctx)
+	}
+
+	start := time.Now()
+	res, err := next(ctx)
+	t.metrics.resolvers.WithLabelValues(fc.Object, fc.Field.Name, status(err)).Inc()
+	t.metrics.resolverDuration.WithLabelValues(fc.Object, fc.Field.Name).Observe(time.Since(start).Seconds())
+	return res, err
+}
+
+func (m *Metrics) operationLabel(name string) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.operationNames[name]; ok {
+		return name
+	}
+	if len(m.operationNames) >= MaxOperationNames {
+		return OtherOperation
+	}
+	m.operationNames[name] = struct{}{}
+	return name
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"sync"
+
+	"postsandcomments/internal/graph"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "postsandcomments"
+
+// MaxOperationNames limits the values of the operation label. Operation names
+// come from clients, names seen after the first MaxOperationNames are counted
+// as OtherOperation.
+const (
+	MaxOperationNames = 100
+	OtherOperation    = "other"
+)
+
+// Metrics keeps its own registry instead of the global one, so a process can
+// run several servers, as the tests do.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	operations        *prometheus.CounterVec
+	operationDuration *prometheus.HistogramVec
+	resolvers         *prometheus.CounterVec
+	resolverDuration  *prometheus.HistogramVec
+	dbDuration        *prometheus.HistogramVec
+	dbErrors          *prometheus.CounterVec
+
+	mutex          sync.Mutex
+	operationNames map[string]struct{}
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry:       prometheus.NewRegistry(),
+		operationNames: make(map[string]struct{}),
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "graphql_operations_total",
+			Help:      "GraphQL queries and mutations by operation name and status.",
+		}, []string{"type", "operation", "status"}),
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "graphql_operation_duration_seconds",
+			Help:      "Time from parsing a GraphQL query or mutation to its response.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"type", "operation"}),
+		resolvers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "graphql_resolvers_total",
+			Help:      "Calls of field resolvers by status.",
+		}, []string{"object", "field", "status"}),
+		resolverDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "graphql_resolver_duration_seconds",
+			Help:      "Time spent in field resolvers.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"object", "field"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_duration_seconds",
+			Help:      "Time spent in Database methods by storage backend.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"backend", "method"}),
+		dbErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "db_errors_total",
+			Help:      "Errors returned by Database methods by storage backend.",
+		}, []string{"backend", "method"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.operations,
+		m.operationDuration,
+		m.resolvers,
+		m.resolverDuration,
+		m.dbDuration,
+		m.dbErrors,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// RegisterSubscriptions exports active subscriptions and event counters of the manager.
+func (m *Metrics) RegisterSubscriptions(manager *graph.SubscriptionManager) error {
+	return m.registry.Register(&subscriptionCollector{manager: manager})
+}
+
+// RegisterDBStats exports the connection pool stats of the database.
+func (m *Metrics) RegisterDBStats(name string, db *sql.DB) error {
+	return m.registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+func status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,117 @@
+package metrics_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/eventbus"
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/metrics"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	body, err := io.ReadAll(recorder.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func newServer(t *testing.T, m *metrics.Metrics) *handler.Server {
+	manager, err := graph.NewSubscriptionManager(graph.SubscriptionConfig{})
+	require.NoError(t, err)
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{
+		DataBase:            m.InstrumentDatabase("memory", db.NewInMemoryDB()),
+		SubscriptionManager: manager,
+		Logger:              logrus.New(),
+	}}))
+	srv.AddTransport(transport.POST{})
+	srv.Use(m.Tracer())
+	return srv
+}
+
+func post(srv *handler.Server, query string) {
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(query))
+	req.Header.Set("Content-Type", "application/json")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestGraphQLAndDatabaseMetrics(t *testing.T) {
+	m := metrics.New()
+	srv := newServer(t, m)
+
+	post(srv, `{"query": "query GetPosts { posts { edges { node { id } } } }"}`)
+	post(srv, `{"query": "query GetPost { post(id: \"missing\") { id } }"}`)
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `postsandcomments_graphql_operations_total{operation="GetPosts",status="ok",type="query"} 1`)
+	assert.Contains(t, body, `postsandcomments_graphql_operations_total{operation="GetPost",status="error",type="query"} 1`)
+	assert.Contains(t, body, `postsandcomments_graphql_operation_duration_seconds_count{operation="GetPosts",type="query"} 1`)
+	assert.Contains(t, body, `postsandcomments_graphql_resolvers_total{field="posts",object="Query",status="ok"} 1`)
+	assert.Contains(t, body, `postsandcomments_graphql_resolvers_total{field="post",object="Query",status="error"} 1`)
+	assert.Contains(t, body, `postsandcomments_db_duration_seconds_count{backend="memory",method="GetPosts"} 1`)
+	assert.Contains(t, body, `postsandcomments_db_errors_total{backend="memory",method="GetPostById"} 1`)
+	assert.NotContains(t, body, `postsandcomments_db_errors_total{backend="memory",method="GetPosts"}`)
+}
+
+func TestOperationNamesAreCapped(t *testing.T) {
+	m := metrics.New()
+	srv := newServer(t, m)
+
+	for i := 0; i <= metrics.MaxOperationNames; i++ {
+		post(srv, fmt.Sprintf(`{"query": "query Q%d { posts { edges { node { id } } } }"}`, i))
+	}
+	// Names seen before the cap keep their label.
+	post(srv, `{"query": "query Q0 { posts { edges { node { id } } } }"}`)
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `postsandcomments_graphql_operations_total{operation="Q0",status="ok",type="query"} 2`)
+	assert.Contains(t, body, fmt.Sprintf(`postsandcomments_graphql_operations_total{operation="Q%d",status="ok",type="query"} 1`, metrics.MaxOperationNames-1))
+	assert.NotContains(t, body, fmt.Sprintf(`operation="Q%d"`, metrics.MaxOperationNames))
+	assert.Contains(t, body, `postsandcomments_graphql_operations_total{operation="other",status="ok",type="query"} 1`)
+}
+
+func TestSubscriptionMetrics(t *testing.T) {
+	m := metrics.New()
+	manager, err := graph.NewSubscriptionManager(graph.SubscriptionConfig{QueueSize: 1, Policy: graph.DropNewest})
+	require.NoError(t, err)
+	require.NoError(t, m.RegisterSubscriptions(manager))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager.Subscribe(ctx, eventbus.CommentAdded, "post_id")
+	manager.Subscribe(ctx, eventbus.CommentAdded, "post_id")
+	manager.Subscribe(ctx, eventbus.PostAdded, "")
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `postsandcomments_subscriptions_active{event="commentAdded",post_id="post_id"} 2`)
+	assert.Contains(t, body, `postsandcomments_subscriptions_active{event="postAdded",post_id=""} 1`)
+
+	// Nobody reads the channels, so the queues fill up and the rest is dropped.
+	for i := 0; i < 3; i++ {
+		manager.Publish(ctx, &eventbus.Event{Type: eventbus.CommentAdded, PostID: "post_id"})
+	}
+	body = scrape(t, m)
+	assert.Contains(t, body, "postsandcomments_subscription_events_published_total 3")
+	assert.Contains(t, body, "postsandcomments_subscription_events_dropped_total")
+	assert.NotContains(t, body, "postsandcomments_subscription_events_dropped_total 0")
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		return !strings.Contains(scrape(t, m), "postsandcomments_subscriptions_active{")
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"postsandcomments/internal/graph"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	activeSubscriptionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "subscriptions_active"),
+		"Active subscriptions by event and post, post_id is empty for postAdded.",
+		[]string{"event", "post_id"}, nil,
+	)
+	publishedEventsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "subscription_events_published_total"),
+		"Events received from the event bus.",
+		nil, nil,
+	)
+	deliveredEventsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "subscription_events_delivered_total"),
+		"Events delivered to subscribers.",
+		nil, nil,
+	)
+	droppedEventsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "subscription_events_dropped_total"),
+		"Events dropped because a subscriber fell behind.",
+		nil, nil,
+	)
+	disconnectedSubscribersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "subscriptions_disconnected_total"),
+		"Subscribers disconnected because they fell behind.",
+		nil, nil,
+	)
+)
+
+// subscriptionCollector reads the manager on every scrape, so topics without
+// subscribers disappear instead of staying at zero.
+type subscriptionCollector struct {
+	manager *graph.SubscriptionManager
+}
+
+func (c *subscriptionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeSubscriptionsDesc
+	ch <- publishedEventsDesc
+	ch <- deliveredEventsDesc
+	ch <- droppedEventsDesc
+	ch <- disconnectedSubscribersDesc
+}
+
+func (c *subscriptionCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, topic := range c.manager.Topics() {
+		ch <- prometheus.MustNewConstMetric(activeSubscriptionsDesc, prometheus.GaugeValue,
+			float64(topic.Subscribers), string(topic.EventType), topic.PostID)
+	}
+
+	stats := c.manager.Stats()
+	ch <- prometheus.MustNewConstMetric(publishedEventsDesc, prometheus.CounterValue, float64(stats.Published))
+	ch <- prometheus.MustNewConstMetric(deliveredEventsDesc, prometheus.CounterValue, float64(stats.Delivered))
+	ch <- prometheus.MustNewConstMetric(droppedEventsDesc, prometheus.CounterValue, float64(stats.Dropped))
+	ch <- prometheus.MustNewConstMetric(disconnectedSubscribersDesc, prometheus.CounterValue, float64(stats.Disconnected))
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// APIKeyHeader carries the API key of a client that has no user token.
+const APIKeyHeader = "X-API-Key"
+
+type clientIPKey struct{}
+
+type apiClientKey struct{}
+
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP returns the address put into ctx by Middleware, or an empty string.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+func WithAPIClient(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, apiClientKey{}, id)
+}
+
+// APIClient returns the client id of the API key put into ctx by Middleware,
+// or an empty string.
+func APIClient(ctx context.Context) string {
+	id, _ := ctx.Value(apiClientKey{}).(string)
+	return id
+}
+
+// Middleware puts the address of the client into the request context. Behind
+// a reverse proxy trustProxy takes the address the proxy appended to
+// X-Forwarded-For, without a proxy the header is set by clients and ignored.
+//
+// apiKeys maps API keys to client ids. A known key in APIKeyHeader puts the
+// client id into the context too, unknown keys are ignored, so clients cannot
+// get fresh buckets by making keys up.
+func Middleware(trustProxy bool, apiKeys map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := WithClientIP(r.Context(), clientIP(r, trustProxy))
+			if id, ok := apiKeys[r.Header.Get(APIKeyHeader)]; ok && id != "" {
+				ctx = WithAPIClient(ctx, id)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
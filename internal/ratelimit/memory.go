@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const cleanupInterval = time.Minute
+
+// MemoryLimiter keeps buckets in the process, every replica limits on its own.
+type MemoryLimiter struct {
+	mutex sync.Mutex
+	// tats are the theoretical arrival times of the next request per key.
+	// A bucket with its time in the past is full and is removed by cleanup.
+	tats        map[string]time.Time
+	lastCleanup time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		tats:        make(map[string]time.Time),
+		lastCleanup: time.Now(),
+	}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastCleanup) > cleanupInterval {
+		l.cleanup(now)
+	}
+
+	tat := l.tats[key]
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(limit.interval())
+	if allowAt := next.Add(-limit.Per); allowAt.After(now) {
+		return false, allowAt.Sub(now), nil
+	}
+
+	l.tats[key] = next
+	return true, 0, nil
+}
+
+func (l *MemoryLimiter) cleanup(now time.Time) {
+	for key, tat := range l.tats {
+		if tat.Before(now) {
+			delete(l.tats, key)
+		}
+	}
+	l.lastCleanup = now
+}
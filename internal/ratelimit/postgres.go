@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PostgresLimiter keeps buckets in the rate_limits table, so all replicas
+// share them. Times come from the database clock, replica clocks don't matter.
+type PostgresLimiter struct {
+	db          *sql.DB
+	lastCleanup atomic.Int64
+}
+
+func NewPostgresLimiter(db *sql.DB) *PostgresLimiter {
+	l := &PostgresLimiter{db: db}
+	l.lastCleanup.Store(time.Now().UnixNano())
+	return l
+}
+
+// Allow moves the arrival time of the bucket forward in a single statement,
+// which does not update the row when the request is denied.
+func (l *PostgresLimiter) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	l.cleanup(ctx)
+
+	query := `
+		INSERT INTO rate_limits AS r (key, tat) VALUES ($1, now() + $2::double precision * interval '1 second')
+		ON CONFLICT (key) DO UPDATE SET tat = GREATEST(r.tat, now()) + $2::double precision * interval '1 second'
+		WHERE GREATEST(r.tat, now()) + $2::double precision * interval '1 second' <= now() + $3::double precision * interval '1 second'`
+	result, err := l.db.ExecContext(ctx, query, key, limit.interval().Seconds(), limit.Per.Seconds())
+	if err != nil {
+		return false, 0, fmt.Errorf("error to take request from bucket: %v", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, 0, fmt.Errorf("error to take request from bucket: %v", err)
+	}
+	if updated > 0 {
+		return true, 0, nil
+	}
+
+	query = `
+		SELECT EXTRACT(EPOCH FROM GREATEST(tat, now()) + $2::double precision * interval '1 second' - $3::double precision * interval '1 second' - now())
+		FROM rate_limits WHERE key = $1`
+	var retryAfter float64
+	err = l.db.QueryRowContext(ctx, query, key, limit.interval().Seconds(), limit.Per.Seconds()).Scan(&retryAfter)
+	if err != nil {
+		return false, 0, fmt.Errorf("error to get retry time of bucket: %v", err)
+	}
+	return false, time.Duration(retryAfter * float64(time.Second)), nil
+}
+
+// cleanup removes full buckets at most once a minute per replica.
+func (l *PostgresLimiter) cleanup(ctx context.Context) {
+	last := l.lastCleanup.Load()
+	now := time.Now().UnixNano()
+	if time.Duration(now-last) < cleanupInterval || !l.lastCleanup.CompareAndSwap(last, now) {
+		return
+	}
+
+	if _, err := l.db.ExecContext(ctx, "DELETE FROM rate_limits WHERE tat < now()"); err != nil {
+		logrus.Warnf("error to remove full rate limit buckets: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"postsandcomments/internal/auth"
+)
+
+// Operations limited by the resolvers. Opening any subscription counts
+// against Subscription.
+const (
+	CreatePost    = "createPost"
+	CreateComment = "createComment"
+	Subscription  = "subscription"
+)
+
+// Limit allows Requests per Per, all of them may come at once. Requests are
+// spread evenly after a burst: one every Per / Requests.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Requests)
+}
+
+// Limiter keeps the buckets of all clients. Both implementations use the
+// generic cell rate algorithm, so a bucket is a single timestamp.
+type Limiter interface {
+	// Allow takes a request from the bucket of key. When it is denied,
+	// retryAfter is how long until the next request is allowed.
+	Allow(ctx context.Context, key string, limit Limit) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// Error is returned for a denied request.
+type Error struct {
+	Operation  string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rate limit of %s exceeded, retry in %s", e.Operation, e.RetryAfter)
+}
+
+// RetryAfterSeconds rounds up, so a client that waits that long is allowed.
+func (e *Error) RetryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// Policy limits operations by their name, operations without a limit or with
+// zero Requests are not limited.
+type Policy struct {
+	Limiter Limiter
+	Limits  map[string]Limit
+}
+
+// Allow returns an *Error if the client of ctx is over the limit of the
+// operation. Clients with a user token are limited by user, clients with an
+// API key by the client id of the key and the rest by IP.
+func (p *Policy) Allow(ctx context.Context, operation string) error {
+	if p == nil {
+		return nil
+	}
+	limit, ok := p.Limits[operation]
+	if !ok || limit.Requests <= 0 || limit.Per <= 0 {
+		return nil
+	}
+
+	allowed, retryAfter, err := p.Limiter.Allow(ctx, operation+":"+clientKey(ctx), limit)
+	if err != nil {
+		return fmt.Errorf("error to check rate limit: %v", err)
+	}
+	if !allowed {
+		return &Error{Operation: operation, RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+func clientKey(ctx context.Context) string {
+	if user := auth.ForContext(ctx); user != nil {
+		return "user:" + user.ID
+	}
+	if id := APIClient(ctx); id != "" {
+		return "apikey:" + id
+	}
+	return "ip:" + ClientIP(ctx)
+}
@@ -0,0 +1,164 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/auth"
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/ratelimit"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLimiter(t *testing.T, limiter ratelimit.Limiter) {
+	ctx := context.Background()
+	key := uuid.New().String()
+	limit := ratelimit.Limit{Requests: 2, Per: time.Hour}
+
+	for i := 0; i < limit.Requests; i++ {
+		allowed, _, err := limiter.Allow(ctx, key, limit)
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	// The bucket refills one request every half an hour.
+	allowed, retryAfter, err := limiter.Allow(ctx, key, limit)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.InDelta(t, 30*time.Minute, retryAfter, float64(time.Second))
+
+	allowed, _, err = limiter.Allow(ctx, uuid.New().String(), limit)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	testLimiter(t, ratelimit.NewMemoryLimiter())
+}
+
+func TestMemoryLimiterRefills(t *testing.T) {
+	limiter := ratelimit.NewMemoryLimiter()
+	limit := ratelimit.Limit{Requests: 1, Per: 50 * time.Millisecond}
+
+	allowed, _, err := limiter.Allow(context.Background(), "key", limit)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, _, err = limiter.Allow(context.Background(), "key", limit)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	time.Sleep(limit.Per)
+	allowed, _, err = limiter.Allow(context.Background(), "key", limit)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+// TestPostgresLimiter needs the same POSTGRES_TEST_HOST as the Postgres tests of the db package.
+func TestPostgresLimiter(t *testing.T) {
+	host := os.Getenv("POSTGRES_TEST_HOST")
+	if host == "" {
+		t.Skip("set POSTGRES_TEST_HOST to run Postgres tests")
+	}
+
+	postgres, err := db.NewPostgresDB(context.Background(), host, 5432, "postgres", "password")
+	require.NoError(t, err)
+	defer postgres.DB.Close()
+
+	testLimiter(t, ratelimit.NewPostgresLimiter(postgres.DB))
+}
+
+func TestPolicy(t *testing.T) {
+	policy := &ratelimit.Policy{
+		Limiter: ratelimit.NewMemoryLimiter(),
+		Limits: map[string]ratelimit.Limit{
+			ratelimit.CreateComment: {Requests: 1, Per: time.Minute},
+		},
+	}
+	user := auth.WithUser(context.Background(), &auth.User{ID: "test_user_id"})
+	anonymous := ratelimit.WithClientIP(context.Background(), "192.0.2.1")
+
+	require.NoError(t, policy.Allow(user, ratelimit.CreateComment))
+	err := policy.Allow(user, ratelimit.CreateComment)
+	var limited *ratelimit.Error
+	require.True(t, errors.As(err, &limited))
+	assert.Equal(t, ratelimit.CreateComment, limited.Operation)
+	assert.Equal(t, 60, limited.RetryAfterSeconds())
+
+	// Anonymous clients have buckets of their own.
+	assert.NoError(t, policy.Allow(anonymous, ratelimit.CreateComment))
+	assert.Error(t, policy.Allow(anonymous, ratelimit.CreateComment))
+	assert.NoError(t, policy.Allow(ratelimit.WithClientIP(context.Background(), "192.0.2.2"), ratelimit.CreateComment))
+
+	// So do API clients, whatever their IP is.
+	apiClient := ratelimit.WithAPIClient(anonymous, "test_client_id")
+	assert.NoError(t, policy.Allow(apiClient, ratelimit.CreateComment))
+	assert.Error(t, policy.Allow(ratelimit.WithAPIClient(context.Background(), "test_client_id"), ratelimit.CreateComment))
+
+	// Operations without a limit are not limited.
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, policy.Allow(user, ratelimit.CreatePost))
+	}
+
+	var disabled *ratelimit.Policy
+	assert.NoError(t, disabled.Allow(user, ratelimit.CreateComment))
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		forwarded  []string
+		want       string
+	}{
+		{"RemoteAddr", false, nil, "192.0.2.1"},
+		{"UntrustedForwardedFor", false, []string{"198.51.100.1"}, "192.0.2.1"},
+		{"ForwardedFor", true, []string{"198.51.100.1, 198.51.100.2"}, "198.51.100.2"},
+		{"SeveralForwardedFor", true, []string{"198.51.100.1", "198.51.100.3"}, "198.51.100.3"},
+		{"NoForwardedFor", true, nil, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ip string
+			handler := ratelimit.Middleware(tt.trustProxy, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip = ratelimit.ClientIP(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/query", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			for _, forwarded := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", forwarded)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.want, ip)
+		})
+	}
+}
+
+func TestMiddlewareAPIKey(t *testing.T) {
+	apiKeys := map[string]string{"test_api_key": "test_client_id"}
+	for key, want := range map[string]string{
+		"test_api_key":  "test_client_id",
+		"wrong_api_key": "",
+		"":              "",
+	} {
+		var id string
+		handler := ratelimit.Middleware(false, apiKeys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id = ratelimit.APIClient(r.Context())
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, "/query", nil)
+		if key != "" {
+			req.Header.Set(ratelimit.APIKeyHeader, key)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, want, id, key)
+	}
+}
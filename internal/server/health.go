@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK           = "ok"
+	StatusError        = "error"
+	StatusStarting     = "starting"
+	StatusShuttingDown = "shutting_down"
+)
+
+const DefaultCheckTimeout = 2 * time.Second
+
+// Check reports whether a component the service depends on works.
+type Check func(ctx context.Context) error
+
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type HealthStatus struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+// Health serves the probes. The service is starting until SetStarted is
+// called and shutting down after SetShuttingDown, it is not ready in both.
+type Health struct {
+	// CheckTimeout limits every check, DefaultCheckTimeout if zero.
+	CheckTimeout time.Duration
+
+	mutex  sync.RWMutex
+	state  string
+	checks map[string]Check
+}
+
+func NewHealth() *Health {
+	return &Health{
+		state:  StatusStarting,
+		checks: make(map[string]Check),
+	}
+}
+
+// AddCheck adds a component to the readiness probe.
+func (h *Health) AddCheck(name string, check Check) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.checks[name] = check
+}
+
+func (h *Health) SetStarted() {
+	h.setState(StatusOK)
+}
+
+func (h *Health) SetShuttingDown() {
+	h.setState(StatusShuttingDown)
+}
+
+func (h *Health) setState(state string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.state = state
+}
+
+// Liveness answers as long as the process serves requests.
+func (h *Health) Liveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, HealthStatus{Status: StatusOK})
+	})
+}
+
+// Startup is not ready until the storage is opened, which for Postgres
+// includes waiting for the database to come up.
+func (h *Health) Startup() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mutex.RLock()
+		state := h.state
+		h.mutex.RUnlock()
+
+		if state == StatusStarting {
+			writeHealth(w, HealthStatus{Status: StatusStarting})
+			return
+		}
+		writeHealth(w, HealthStatus{Status: StatusOK})
+	})
+}
+
+// Readiness runs all checks concurrently and reports the status of every component.
+func (h *Health) Readiness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, h.Ready(r.Context()))
+	})
+}
+
+func (h *Health) Ready(ctx context.Context) HealthStatus {
+	h.mutex.RLock()
+	state := h.state
+	checks := make(map[string]Check, len(h.checks))
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.mutex.RUnlock()
+
+	if state != StatusOK {
+		return HealthStatus{Status: state}
+	}
+
+	timeout := h.CheckTimeout
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	status := HealthStatus{Status: StatusOK, Components: make(map[string]ComponentStatus, len(checks))}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			component := ComponentStatus{Status: StatusOK}
+			if err := check(ctx); err != nil {
+				component = ComponentStatus{Status: StatusError, Error: err.Error()}
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			status.Components[name] = component
+			if component.Status != StatusOK {
+				status.Status = StatusError
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	return status
+}
+
+func writeHealth(w http.ResponseWriter, status HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if status.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
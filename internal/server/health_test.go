@@ -0,0 +1,56 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadiness(t *testing.T) {
+	health := server.NewHealth()
+	health.CheckTimeout = 50 * time.Millisecond
+	health.AddCheck("database", func(ctx context.Context) error { return nil })
+	health.AddCheck("eventbus", func(ctx context.Context) error { return errors.New("connection refused") })
+	health.AddCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	readiness := func() (int, server.HealthStatus) {
+		recorder := httptest.NewRecorder()
+		health.Readiness().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var status server.HealthStatus
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&status))
+		return recorder.Code, status
+	}
+
+	code, status := readiness()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, server.HealthStatus{Status: server.StatusStarting}, status)
+
+	health.SetStarted()
+	code, status = readiness()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, server.HealthStatus{
+		Status: server.StatusError,
+		Components: map[string]server.ComponentStatus{
+			"database": {Status: server.StatusOK},
+			"eventbus": {Status: server.StatusError, Error: "connection refused"},
+			"slow":     {Status: server.StatusError, Error: context.DeadlineExceeded.Error()},
+		},
+	}, status)
+
+	health.SetShuttingDown()
+	code, status = readiness()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, server.HealthStatus{Status: server.StatusShuttingDown}, status)
+}
@@ -1,30 +1,254 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"postsandcomments/internal/graph"
+	"postsandcomments/internal/auth"
 	"postsandcomments/internal/db"
+	"postsandcomments/internal/eventbus"
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/metrics"
+	"postsandcomments/internal/ratelimit"
+	"postsandcomments/internal/tracing"
+	"postsandcomments/internal/webhook"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/sirupsen/logrus"
 )
 
-func StartServer(port string, db db.Database) {
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Config sets up the http.Server, zero timeouts mean no timeout. Read and write
+// timeouts do not apply to subscriptions, which stream for as long as clients stay.
+type Config struct {
+	Port              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is how long in-flight requests are drained on shutdown,
+	// DefaultShutdownTimeout if zero.
+	ShutdownTimeout time.Duration
+	// Limits rejects GraphQL operations that are too deep or too complex and
+	// bounds how long queries and mutations run.
+	Limits graph.Limits
+	// TrustProxy takes the client address from X-Forwarded-For for rate limits,
+	// it must be set only behind a reverse proxy that appends to the header.
+	TrustProxy bool
+	// APIKeys maps API keys to client ids, clients sending a key in
+	// ratelimit.APIKeyHeader are rate limited by the client id.
+	APIKeys map[string]string
+}
+
+// API is what the GraphQL endpoints need. The caller closes Webhooks, Bus and
+// Database after StartServer returns.
+type API struct {
+	Database      db.Database
+	Authenticator *auth.Authenticator
+	Subscriptions *graph.SubscriptionManager
+	Webhooks      *webhook.Dispatcher
+	Bus           eventbus.Bus
+	// Metrics records GraphQL operations and is served on /metrics if it is not nil.
+	Metrics *metrics.Metrics
+	// RateLimits may be nil, then nothing is limited.
+	RateLimits *ratelimit.Policy
+}
+
+// StartServer starts listening right away, so probes are answered while open
+// connects to the storage, and serves the API once open returns. The database
+// and the event bus are added to the readiness checks of health. open gets ctx,
+// so it can stop waiting for the storage when the server is stopped.
+//
+// When ctx is done it shuts down gracefully: it stops accepting connections,
+// closes all subscriptions, waits up to ShutdownTimeout for in-flight requests
+// and closes websocket connections.
+func StartServer(ctx context.Context, config Config, health *Health, open func(ctx context.Context) (*API, error)) error {
+	api := &apiHandler{}
+	mux := http.NewServeMux()
+	mux.Handle("GET /healthz", health.Liveness())
+	mux.Handle("GET /readyz", health.Readiness())
+	mux.Handle("GET /startupz", health.Startup())
+	mux.Handle("/", api)
+
+	// Websocket connections are hijacked, so Shutdown does not wait for them
+	// and they are closed by canceling the base context of all requests.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	httpServer := &http.Server{
+		Handler:           mux,
+		ReadTimeout:       config.ReadTimeout,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+	}
+
+	listener, err := net.Listen("tcp", ":"+config.Port)
+	if err != nil {
+		return err
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.Serve(listener)
+	}()
+
+	deps, err := open(ctx)
+	if err != nil {
+		httpServer.Close()
+		return err
+	}
+	api.handler.Store(newAPIHandler(deps, config))
+	health.AddCheck("database", deps.Database.Ping)
+	health.AddCheck("eventbus", deps.Bus.Ping)
+	health.SetStarted()
+	log.Printf("connect to http://localhost:%s/ for GraphQL playground", config.Port)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownTimeout := config.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = DefaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	logrus.Infof("shutting down, draining requests for up to %s", shutdownTimeout)
+	health.SetShuttingDown()
+	// Subscriptions never end on their own, Shutdown would wait for SSE streams
+	// until the deadline.
+	deps.Subscriptions.Close()
+	shutdownErr := httpServer.Shutdown(shutdownCtx)
+	cancelBase()
+	if shutdownErr != nil {
+		httpServer.Close()
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if shutdownErr != nil {
+		return fmt.Errorf("error to drain requests: %v", shutdownErr)
+	}
+
+	logrus.Infof("server stopped")
+	return nil
+}
+
+// apiHandler answers 503 until the API is opened.
+type apiHandler struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler := h.handler.Load()
+	if handler == nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "service is starting", http.StatusServiceUnavailable)
+		return
+	}
+	(*handler).ServeHTTP(w, r)
+}
+
+func newAPIHandler(api *API, config Config) *http.Handler {
+	resolver := &graph.Resolver{
+		DataBase:            api.Database,
+		SubscriptionManager: api.Subscriptions,
+		Webhooks:            api.Webhooks,
+		RateLimits:          api.RateLimits,
+		Logger:              logrus.New(),
+	}
 	cfg := graph.Config{
-		Resolvers: &graph.Resolver{
-			DataBase:            db,
-			SubscriptionManager: graph.NewSubscriptionManager(),
-			Logger:              logrus.New(),
-		},
+		Resolvers:  resolver,
+		Complexity: graph.NewComplexity(),
+	}
+
+	srv := handler.New(graph.NewExecutableSchema(cfg))
+	srv.AddTransport(transport.Websocket{
+		KeepAlivePingInterval: 10 * time.Second,
+		InitFunc:              api.Authenticator.WebsocketInit,
+	})
+	// graphql-sse for clients behind proxies that break websockets, it has to
+	// go before POST, which would take these requests too.
+	srv.AddTransport(transport.SSE{})
+	srv.AddTransport(transport.Options{})
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+	srv.AddTransport(transport.MultipartForm{})
+
+	srv.SetQueryCache(lru.New(1000))
+	srv.SetErrorPresenter(graph.NewErrorPresenter(resolver.Logger))
+
+	srv.Use(extension.Introspection{})
+	srv.Use(extension.AutomaticPersistedQuery{
+		Cache: lru.New(100),
+	})
+	limits := config.Limits
+	srv.Use(&limits)
+	srv.AroundOperations(auth.RequireUserForMutations)
+	srv.AroundOperations(graph.BatchUserLookups(resolver.DataBase))
+	srv.Use(tracing.Tracer{})
+	if api.Metrics != nil {
+		srv.Use(api.Metrics.Tracer())
 	}
 
-	srv := handler.NewDefaultServer(graph.NewExecutableSchema(cfg))
-	http.Handle("/", playground.Handler("GraphQL playground", "/query"))
-	http.Handle("/query", srv)
+	// expvar panics on a second Publish of the same name.
+	if expvar.Get("subscriptions") == nil {
+		expvar.Publish("subscriptions", expvar.Func(func() interface{} {
+			return api.Subscriptions.Stats()
+		}))
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", playground.Handler("GraphQL playground", "/query"))
+	mux.Handle("/query", streaming(api.Authenticator.Middleware(srv)))
+	mux.Handle("GET /posts/{id}/comments/stream", api.Authenticator.Middleware(&CommentStream{
+		Subscriptions: resolver.Subscription(),
+		Logger:        resolver.Logger,
+	}))
+	mux.Handle("/debug/vars", expvar.Handler())
+	if api.Metrics != nil {
+		mux.Handle("GET /metrics", api.Metrics.Handler())
+	}
 
-	log.Printf("connect to http://localhost:%s/ for GraphQL playground", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	var h http.Handler = tracing.Middleware(ratelimit.Middleware(config.TrustProxy, config.APIKeys)(mux))
+	return &h
 }
 
+// streaming lifts the read and write timeouts from graphql-sse responses.
+// Websocket connections clear their deadlines themselves when they are upgraded.
+func streaming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			liftDeadlines(w)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// liftDeadlines keeps a stream open past ReadTimeout and WriteTimeout.
+func liftDeadlines(w http.ResponseWriter) {
+	controller := http.NewResponseController(w)
+	if err := controller.SetReadDeadline(time.Time{}); err != nil {
+		logrus.Warnf("error to lift read deadline of stream: %v", err)
+	}
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		logrus.Warnf("error to lift write deadline of stream: %v", err)
+	}
+}
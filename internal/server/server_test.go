@@ -0,0 +1,202 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/auth"
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/eventbus"
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/server"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "test_secret"
+
+func freePort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func query(t *testing.T, url, token, body string) map[string]interface{} {
+	payload, err := json.Marshal(map[string]string{"query": body})
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, url+"/query", strings.NewReader(string(payload)))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var result struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []interface{}          `json:"errors"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.Empty(t, result.Errors)
+	return result.Data
+}
+
+func probe(t *testing.T, url string) *http.Response {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestStartServerGracefulShutdown(t *testing.T) {
+	database := db.NewInMemoryDB()
+	authenticator, err := auth.NewAuthenticator(auth.Config{HS256Secret: testSecret})
+	require.NoError(t, err)
+	bus := eventbus.NewLocalBus()
+	subscriptions, err := graph.NewSubscriptionManager(graph.SubscriptionConfig{Bus: bus})
+	require.NoError(t, err)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "test_user_id",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(testSecret))
+	require.NoError(t, err)
+
+	port := freePort(t)
+	url := "http://127.0.0.1:" + port
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	health := server.NewHealth()
+	opened := make(chan struct{})
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.StartServer(ctx, server.Config{
+			Port:            port,
+			ReadTimeout:     100 * time.Millisecond,
+			WriteTimeout:    100 * time.Millisecond,
+			ShutdownTimeout: time.Second,
+		}, health, func(context.Context) (*server.API, error) {
+			<-opened
+			return &server.API{
+				Database:      database,
+				Authenticator: authenticator,
+				Subscriptions: subscriptions,
+				Bus:           bus,
+			}, nil
+		})
+	}()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// Probes are answered while the storage is being opened.
+	assert.Equal(t, http.StatusOK, probe(t, url+"/healthz").StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, probe(t, url+"/startupz").StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, probe(t, url+"/readyz").StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, probe(t, url+"/query").StatusCode)
+
+	close(opened)
+	require.Eventually(t, func() bool {
+		return probe(t, url+"/readyz").StatusCode == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusOK, probe(t, url+"/startupz").StatusCode)
+	var status server.HealthStatus
+	require.NoError(t, json.NewDecoder(probe(t, url+"/readyz").Body).Decode(&status))
+	assert.Equal(t, server.HealthStatus{
+		Status: server.StatusOK,
+		Components: map[string]server.ComponentStatus{
+			"database": {Status: server.StatusOK},
+			"eventbus": {Status: server.StatusOK},
+		},
+	}, status)
+
+	post := query(t, url, token, `mutation { createPost(title: "Test Post", body: "Test body", allowComments: true) { id } }`)
+	postID := post["createPost"].(map[string]interface{})["id"].(string)
+
+	resp, err := http.Get(url + "/posts/" + postID + "/comments/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	lines := make(chan string, 10)
+	go func() {
+		defer close(lines)
+		buf := make([]byte, 4096)
+		for {
+			n, err := resp.Body.Read(buf)
+			if n > 0 {
+				lines <- string(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// The stream outlives the read and write timeouts.
+	time.Sleep(300 * time.Millisecond)
+	query(t, url, token, fmt.Sprintf(`mutation { createComment(postId: %q, body: "Test Comment") { id } }`, postID))
+	streamed := ""
+	require.Eventually(t, func() bool {
+		select {
+		case line := <-lines:
+			streamed += line
+		default:
+		}
+		return strings.Contains(streamed, "Test Comment")
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		return health.Ready(context.Background()).Status == server.StatusShuttingDown
+	}, time.Second, 10*time.Millisecond)
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("server is not stopped")
+	}
+
+	// The stream is closed instead of being cut off at the shutdown deadline.
+	for range lines {
+	}
+	_, err = resp.Body.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	_, err = http.Get(url + "/query")
+	assert.Error(t, err)
+}
+
+func TestStartServerStopsWhileOpening(t *testing.T) {
+	port := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.StartServer(ctx, server.Config{Port: port}, server.NewHealth(), func(ctx context.Context) (*server.API, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	}()
+
+	cancel()
+	select {
+	case err := <-stopped:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("server does not stop while the storage is being opened")
+	}
+}
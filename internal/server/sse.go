@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/graph/model"
+	"postsandcomments/internal/ratelimit"
+
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// CommentStream serves new comments to a post as Server-Sent Events for
+// clients behind proxies that break websockets. The id of every event is the
+// eventId of the comment, so EventSource resumes after a reconnect by sending
+// it back in the Last-Event-ID header.
+type CommentStream struct {
+	Subscriptions graph.SubscriptionResolver
+	// Heartbeat is how often a comment line is sent to keep idle proxies
+	// from closing the connection, DefaultHeartbeatInterval if zero.
+	Heartbeat time.Duration
+	Logger    *logrus.Logger
+}
+
+type streamedComment struct {
+	*model.Comment
+	EventID string `json:"eventId"`
+}
+
+func (s *CommentStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	postID := r.PathValue("id")
+	var afterEventID *string
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		afterEventID = &lastEventID
+	} else if lastEventID := r.URL.Query().Get("lastEventId"); lastEventID != "" {
+		afterEventID = &lastEventID
+	}
+
+	comments, err := s.Subscriptions.CommentAdded(r.Context(), postID, afterEventID)
+	var limited *ratelimit.Error
+	if errors.As(err, &limited) {
+		w.Header().Set("Retry-After", strconv.Itoa(limited.RetryAfterSeconds()))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	var domainErr *graph.Error
+	if errors.As(err, &domainErr) {
+		http.Error(w, domainErr.Message, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	heartbeat := s.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeatInterval
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	liftDeadlines(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Disables response buffering in nginx.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", (3 * time.Second).Milliseconds())
+	flusher.Flush()
+
+	for {
+		select {
+		case comment, ok := <-comments:
+			if !ok {
+				return
+			}
+			eventID := strconv.FormatInt(comment.Seq, 10)
+			data, err := json.Marshal(streamedComment{Comment: comment, EventID: eventID})
+			if err != nil {
+				s.Logger.Errorf("error to encode comment with id = %s: %v", comment.ID, err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: commentAdded\ndata: %s\n\n", eventID, data)
+		case <-ticker.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,179 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/auth"
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/server"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sseEvent struct {
+	id   string
+	name string
+	data string
+}
+
+func newStreamServer(t *testing.T, heartbeat time.Duration) (*httptest.Server, *graph.Resolver) {
+	manager, err := graph.NewSubscriptionManager(graph.SubscriptionConfig{})
+	require.NoError(t, err)
+	resolver := &graph.Resolver{
+		DataBase:            db.NewInMemoryDB(),
+		SubscriptionManager: manager,
+		Logger:              logrus.New(),
+	}
+	authenticator, err := auth.NewAuthenticator(auth.Config{HS256Secret: "test_secret"})
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /posts/{id}/comments/stream", authenticator.Middleware(&server.CommentStream{
+		Subscriptions: resolver.Subscription(),
+		Heartbeat:     heartbeat,
+		Logger:        resolver.Logger,
+	}))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv, resolver
+}
+
+// readEvents reads events from the stream in the background, heartbeats are
+// returned as events named "heartbeat".
+func readEvents(resp *http.Response) <-chan sseEvent {
+	events := make(chan sseEvent, 10)
+	go func() {
+		defer close(events)
+
+		scanner := bufio.NewScanner(resp.Body)
+		var event sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if event != (sseEvent{}) {
+					events <- event
+				}
+				event = sseEvent{}
+			case strings.HasPrefix(line, ":"):
+				event.name = "heartbeat"
+			case strings.HasPrefix(line, "id: "):
+				event.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return events
+}
+
+func nextComment(t *testing.T, events <-chan sseEvent) sseEvent {
+	for {
+		select {
+		case event := <-events:
+			if event.name == "commentAdded" {
+				return event
+			}
+		case <-time.After(time.Second):
+			t.Fatal("comment is not streamed")
+		}
+	}
+}
+
+func openStream(t *testing.T, ctx context.Context, url, lastEventID, token string) *http.Response {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestCommentStream(t *testing.T) {
+	srv, resolver := newStreamServer(t, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	post, err := resolver.Mutation().CreatePost(ctx, "Test Post", "Test body", true)
+	require.NoError(t, err)
+	seen, err := resolver.Mutation().CreateComment(ctx, post.ID, "Seen", nil)
+	require.NoError(t, err)
+	missed, err := resolver.Mutation().CreateComment(ctx, post.ID, "Missed", nil)
+	require.NoError(t, err)
+
+	seenEventID, err := resolver.Comment().EventID(ctx, seen)
+	require.NoError(t, err)
+	resp := openStream(t, ctx, srv.URL+"/posts/"+post.ID+"/comments/stream", seenEventID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	events := readEvents(resp)
+
+	event := nextComment(t, events)
+	missedEventID, err := resolver.Comment().EventID(ctx, missed)
+	require.NoError(t, err)
+	assert.Equal(t, missedEventID, event.id)
+
+	var comment struct {
+		ID      string `json:"id"`
+		Body    string `json:"body"`
+		EventID string `json:"eventId"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(event.data), &comment))
+	assert.Equal(t, missed.ID, comment.ID)
+	assert.Equal(t, "Missed", comment.Body)
+	assert.Equal(t, missedEventID, comment.EventID)
+
+	live, err := resolver.Mutation().CreateComment(ctx, post.ID, "Live", nil)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(nextComment(t, events).data), &comment))
+	assert.Equal(t, live.ID, comment.ID)
+}
+
+func TestCommentStreamHeartbeat(t *testing.T) {
+	srv, _ := newStreamServer(t, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := readEvents(openStream(t, ctx, srv.URL+"/posts/test_post_id/comments/stream", "", ""))
+	for {
+		select {
+		case event := <-events:
+			if event.name == "heartbeat" {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("heartbeat is not sent")
+		}
+	}
+}
+
+func TestCommentStreamErrors(t *testing.T) {
+	srv, _ := newStreamServer(t, time.Minute)
+	ctx := context.Background()
+
+	resp := openStream(t, ctx, srv.URL+"/posts/test_post_id/comments/stream", "", "invalid")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = openStream(t, ctx, srv.URL+"/posts/test_post_id/comments/stream", "not-a-number", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
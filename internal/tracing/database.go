@@ -0,0 +1,154 @@
+package tracing
+
+import (
+	"context"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/graph/model"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceDatabase records a span for every method of the database, statements
+// of a database opened with a WrapDriver driver become its children. Ping and
+// Close are not recorded.
+func TraceDatabase(backend string, database db.Database) db.Database {
+	return &tracedDatabase{Database: database, backend: backend}
+}
+
+type tracedDatabase struct {
+	db.Database
+	backend string
+}
+
+func (d *tracedDatabase) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "db."+method, trace.WithAttributes(
+		attribute.String("db.backend", d.backend),
+		semconv.DBOperationName(method),
+	))
+}
+
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+func (d *tracedDatabase) CreatePost(ctx context.Context, post *model.Post) (err error) {
+	ctx, span := d.start(ctx, "CreatePost")
+	defer endSpan(span, &err)
+	return d.Database.CreatePost(ctx, post)
+}
+
+func (d *tracedDatabase) GetPosts(ctx context.Context, query db.PostQuery) (result []*model.Post, err error) {
+	ctx, span := d.start(ctx, "GetPosts")
+	defer endSpan(span, &err)
+	return d.Database.GetPosts(ctx, query)
+}
+
+func (d *tracedDatabase) GetPostById(ctx context.Context, id string) (result *model.Post, err error) {
+	ctx, span := d.start(ctx, "GetPostById")
+	defer endSpan(span, &err)
+	return d.Database.GetPostById(ctx, id)
+}
+
+func (d *tracedDatabase) UpdatePost(ctx context.Context, post *model.Post) (err error) {
+	ctx, span := d.start(ctx, "UpdatePost")
+	defer endSpan(span, &err)
+	return d.Database.UpdatePost(ctx, post)
+}
+
+func (d *tracedDatabase) DeletePost(ctx context.Context, id string) (err error) {
+	ctx, span := d.start(ctx, "DeletePost")
+	defer endSpan(span, &err)
+	return d.Database.DeletePost(ctx, id)
+}
+
+func (d *tracedDatabase) CreateComment(ctx context.Context, post *model.Post, comment *model.Comment) (err error) {
+	ctx, span := d.start(ctx, "CreateComment")
+	defer endSpan(span, &err)
+	return d.Database.CreateComment(ctx, post, comment)
+}
+
+func (d *tracedDatabase) GetCommentById(ctx context.Context, id string) (result *model.Comment, err error) {
+	ctx, span := d.start(ctx, "GetCommentById")
+	defer endSpan(span, &err)
+	return d.Database.GetCommentById(ctx, id)
+}
+
+func (d *tracedDatabase) GetComments(ctx context.Context, postID string, parentID *string, first int, after *int64) (result []*model.Comment, err error) {
+	ctx, span := d.start(ctx, "GetComments")
+	defer endSpan(span, &err)
+	return d.Database.GetComments(ctx, postID, parentID, first, after)
+}
+
+func (d *tracedDatabase) GetCommentsSince(ctx context.Context, postID string, afterSeq int64, limit int) (result []*model.Comment, err error) {
+	ctx, span := d.start(ctx, "GetCommentsSince")
+	defer endSpan(span, &err)
+	return d.Database.GetCommentsSince(ctx, postID, afterSeq, limit)
+}
+
+func (d *tracedDatabase) UpdateComment(ctx context.Context, comment *model.Comment) (err error) {
+	ctx, span := d.start(ctx, "UpdateComment")
+	defer endSpan(span, &err)
+	return d.Database.UpdateComment(ctx, comment)
+}
+
+func (d *tracedDatabase) DeleteComment(ctx context.Context, id string) (err error) {
+	ctx, span := d.start(ctx, "DeleteComment")
+	defer endSpan(span, &err)
+	return d.Database.DeleteComment(ctx, id)
+}
+
+func (d *tracedDatabase) SaveUser(ctx context.Context, user *model.User) (err error) {
+	ctx, span := d.start(ctx, "SaveUser")
+	defer endSpan(span, &err)
+	return d.Database.SaveUser(ctx, user)
+}
+
+func (d *tracedDatabase) GetUserById(ctx context.Context, id string) (result *model.User, err error) {
+	ctx, span := d.start(ctx, "GetUserById")
+	defer endSpan(span, &err)
+	return d.Database.GetUserById(ctx, id)
+}
+
+func (d *tracedDatabase) GetUsersByIds(ctx context.Context, ids []string) (result []*model.User, err error) {
+	ctx, span := d.start(ctx, "GetUsersByIds")
+	defer endSpan(span, &err)
+	return d.Database.GetUsersByIds(ctx, ids)
+}
+
+func (d *tracedDatabase) CreateWebhook(ctx context.Context, webhook *model.Webhook) (err error) {
+	ctx, span := d.start(ctx, "CreateWebhook")
+	defer endSpan(span, &err)
+	return d.Database.CreateWebhook(ctx, webhook)
+}
+
+func (d *tracedDatabase) GetWebhooks(ctx context.Context) (result []*model.Webhook, err error) {
+	ctx, span := d.start(ctx, "GetWebhooks")
+	defer endSpan(span, &err)
+	return d.Database.GetWebhooks(ctx)
+}
+
+func (d *tracedDatabase) DeleteWebhook(ctx context.Context, id string) (err error) {
+	ctx, span := d.start(ctx, "DeleteWebhook")
+	defer endSpan(span, &err)
+	return d.Database.DeleteWebhook(ctx, id)
+}
+
+func (d *tracedDatabase) CreateDeadLetter(ctx context.Context, delivery *model.WebhookDelivery) (err error) {
+	ctx, span := d.start(ctx, "CreateDeadLetter")
+	defer endSpan(span, &err)
+	return d.Database.CreateDeadLetter(ctx, delivery)
+}
+
+func (d *tracedDatabase) GetDeadLetters(ctx context.Context, webhookID *string, limit int) (result []*model.WebhookDelivery, err error) {
+	ctx, span := d.start(ctx, "GetDeadLetters")
+	defer endSpan(span, &err)
+	return d.Database.GetDeadLetters(ctx, webhookID, limit)
+}
@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer is a gqlgen extension that records a span per operation with a
+// child span per field resolver.
+type Tracer struct{}
+
+var (
+	_ graphql.HandlerExtension     = Tracer{}
+	_ graphql.OperationInterceptor = Tracer{}
+	_ graphql.FieldInterceptor     = Tracer{}
+)
+
+func (Tracer) ExtensionName() string {
+	return "Tracing"
+}
+
+func (Tracer) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+// InterceptOperation ends the span of a query or mutation with its response
+// and the span of a subscription when it ends.
+func (Tracer) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	oc := graphql.GetOperationContext(ctx)
+	operationType := ast.Query
+	name := oc.OperationName
+	if oc.Operation != nil {
+		operationType = oc.Operation.Operation
+		if name == "" {
+			name = oc.Operation.Name
+		}
+	}
+	spanName := string(operationType)
+	if name != "" {
+		spanName += " " + name
+	}
+
+	ctx, span := tracer().Start(ctx, spanName,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.GraphqlOperationTypeKey.String(string(operationType)),
+			semconv.GraphqlOperationName(name),
+		),
+	)
+	handler := next(ctx)
+
+	return func(ctx context.Context) *graphql.Response {
+		resp := handler(ctx)
+		if resp != nil && len(resp.Errors) > 0 {
+			span.SetStatus(codes.Error, resp.Errors.Error())
+		}
+		if resp == nil || operationType != ast.Subscription {
+			span.End()
+		}
+		return resp
+	}
+}
+
+// InterceptField records fields with resolvers, plain struct fields are not worth it.
+func (Tracer) InterceptField(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || !fc.IsResolver {
+		return next(ctx)
+	}
+
+	ctx, span := tracer().Start(ctx, fc.Object+"."+fc.Field.Name,
+		trace.WithAttributes(attribute.String("graphql.field.path", fc.Path().String())),
+	)
+	defer span.End()
+
+	res, err := next(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return res, err
+}
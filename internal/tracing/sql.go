@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WrapDriver returns a database/sql driver that records a span with the SQL
+// text for every query and exec, system is the db.system attribute, e.g.
+// postgresql. Register it with sql.Register under a name of its own.
+func WrapDriver(d driver.Driver, system string) driver.Driver {
+	return &tracedDriver{Driver: d, system: semconv.DBSystemKey.String(system)}
+}
+
+type tracedDriver struct {
+	driver.Driver
+	system attribute.KeyValue
+}
+
+func (d *tracedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: conn, system: d.system}, nil
+}
+
+// tracedConn passes through the optional interfaces database/sql looks for,
+// without them it would fall back to slower paths or skip pings.
+type tracedConn struct {
+	driver.Conn
+	system attribute.KeyValue
+}
+
+var (
+	_ driver.QueryerContext     = &tracedConn{}
+	_ driver.ExecerContext      = &tracedConn{}
+	_ driver.ConnPrepareContext = &tracedConn{}
+	_ driver.ConnBeginTx        = &tracedConn{}
+	_ driver.Pinger             = &tracedConn{}
+	_ driver.SessionResetter    = &tracedConn{}
+	_ driver.Validator          = &tracedConn{}
+)
+
+func (c *tracedConn) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	spanName := "SQL"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		spanName = strings.ToUpper(fields[0])
+	}
+	return tracer().Start(ctx, spanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(c.system, semconv.DBQueryText(query)),
+	)
+}
+
+func endStatement(span trace.Span, err error) {
+	if err != nil && err != driver.ErrSkip {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, span := c.start(ctx, query)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	endStatement(span, err)
+	return rows, err
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, span := c.start(ctx, query)
+	result, err := execer.ExecContext(ctx, query, args)
+	endStatement(span, err)
+	return result, err
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package tracing_test
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/graph"
+	"postsandcomments/internal/graph/model"
+	"postsandcomments/internal/tracing"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"modernc.org/sqlite"
+)
+
+func recordSpans() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+func spansByName(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
+
+func TestGraphQLSpans(t *testing.T) {
+	recorder := recordSpans()
+	database := db.NewInMemoryDB()
+	post := &model.Post{ID: "post_id", Title: "Test Post", Body: "Test body", AllowComments: true}
+	require.NoError(t, database.CreatePost(context.Background(), post))
+
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{
+		DataBase: tracing.TraceDatabase("memory", database),
+		Logger:   logrus.New(),
+	}}))
+	srv.AddTransport(transport.POST{})
+	srv.Use(tracing.Tracer{})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(
+		`{"query": "query GetPost { post(id: \"post_id\") { id comments { edges { node { id } } } } missing: post(id: \"missing\") { id } }"}`,
+	))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	tracing.Middleware(srv).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := spansByName(recorder)
+	operation := spans["query GetPost"]
+	require.NotNil(t, operation)
+	assert.Equal(t, traceID, operation.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", operation.Parent().SpanID().String())
+	assert.Equal(t, codes.Error, operation.Status().Code)
+
+	field := spans["Query.post"]
+	require.NotNil(t, field)
+	assert.Equal(t, operation.SpanContext().SpanID(), field.Parent().SpanID())
+	// Nested fields are children of the field that returned their object.
+	comments := spans["Post.comments"]
+	require.NotNil(t, comments)
+	parents := make(map[string]string)
+	for _, span := range recorder.Ended() {
+		parents[span.SpanContext().SpanID().String()] = span.Name()
+	}
+	assert.Equal(t, "Query.post", parents[comments.Parent().SpanID().String()])
+
+	getPost := spans["db.GetPostById"]
+	require.NotNil(t, getPost)
+	assert.Contains(t, getPost.Attributes(), attribute.String("db.backend", "memory"))
+	getComments := spans["db.GetComments"]
+	require.NotNil(t, getComments)
+	assert.Equal(t, comments.SpanContext().SpanID(), getComments.Parent().SpanID())
+
+	failed := 0
+	for _, span := range recorder.Ended() {
+		if span.Name() == "db.GetPostById" && span.Status().Code == codes.Error {
+			failed++
+		}
+	}
+	assert.Equal(t, 1, failed)
+}
+
+func TestSQLSpans(t *testing.T) {
+	recorder := recordSpans()
+	sql.Register("sqlite-traced", tracing.WrapDriver(&sqlite.Driver{}, "sqlite"))
+	conn, err := sql.Open("sqlite-traced", ":memory:")
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.Ping())
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	_, err = conn.ExecContext(ctx, "CREATE TABLE posts (id TEXT)")
+	require.NoError(t, err)
+	var count int
+	require.NoError(t, conn.QueryRowContext(ctx, "select count(*) from posts WHERE id = ?", "post_id").Scan(&count))
+	_, err = conn.ExecContext(ctx, "DELETE FROM missing")
+	assert.Error(t, err)
+	parent.End()
+
+	spans := spansByName(recorder)
+	create := spans["CREATE"]
+	require.NotNil(t, create)
+	assert.Equal(t, parent.SpanContext().SpanID(), create.Parent().SpanID())
+	assert.Contains(t, create.Attributes(), attribute.String("db.system", "sqlite"))
+	assert.Contains(t, create.Attributes(), attribute.String("db.query.text", "CREATE TABLE posts (id TEXT)"))
+
+	selectSpan := spans["SELECT"]
+	require.NotNil(t, selectSpan)
+	assert.Contains(t, selectSpan.Attributes(), attribute.String("db.query.text", "select count(*) from posts WHERE id = ?"))
+
+	deleteSpan := spans["DELETE"]
+	require.NotNil(t, deleteSpan)
+	assert.Equal(t, codes.Error, deleteSpan.Status().Code)
+}
+
+func TestSetup(t *testing.T) {
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{Exporter: tracing.ExporterNone})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	shutdown, err = tracing.Setup(context.Background(), tracing.Config{Exporter: tracing.ExporterStdout})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = tracing.Setup(context.Background(), tracing.Config{Exporter: "zipkin"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,392 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"postsandcomments/internal/eventbus"
+	"postsandcomments/internal/graph/model"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SignatureHeader is "sha256=" followed by the hex HMAC-SHA256 of
+	// "<timestamp>.<body>" keyed with the secret of the webhook.
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader is the Unix time of the attempt, receivers should reject
+	// old timestamps to stop replays.
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	// DeliveryHeader is the same for every attempt, so receivers can skip duplicates.
+	DeliveryHeader = "X-Webhook-Delivery"
+)
+
+const (
+	DefaultWorkers        = 4
+	DefaultQueueSize      = 1000
+	DefaultMaxAttempts    = 5
+	DefaultInitialBackoff = time.Second
+	DefaultMaxBackoff     = 5 * time.Minute
+	DefaultTimeout        = 10 * time.Second
+)
+
+// Store is the part of db.Database the dispatcher uses.
+type Store interface {
+	GetWebhooks(ctx context.Context) ([]*model.Webhook, error)
+	CreateDeadLetter(ctx context.Context, delivery *model.WebhookDelivery) error
+}
+
+type Config struct {
+	Workers   int
+	QueueSize int
+	// MaxAttempts is how many times a delivery is tried before it becomes a dead letter.
+	MaxAttempts int
+	// The delay before a retry doubles with every attempt from InitialBackoff up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout limits one attempt.
+	Timeout time.Duration
+	Client  *http.Client
+	Logger  *logrus.Logger
+}
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	ID      string             `json:"id"`
+	Event   model.WebhookEvent `json:"event"`
+	PostID  string             `json:"postId"`
+	Post    *model.Post        `json:"post,omitempty"`
+	Comment *model.Comment     `json:"comment,omitempty"`
+}
+
+type delivery struct {
+	id        string
+	webhook   *model.Webhook
+	event     model.WebhookEvent
+	body      []byte
+	attempts  int
+	lastError string
+}
+
+// Dispatcher delivers events to the registered webhooks in the background.
+// The webhooks of an event are looked up by the workers, so Dispatch does not
+// touch the store while the queues have room. Failed deliveries are retried
+// with exponential backoff and saved to the store as dead letters once
+// MaxAttempts is reached. Deliveries waiting in the queue or for a retry are
+// kept in memory only, Close saves them as dead letters so they are not lost
+// silently.
+type Dispatcher struct {
+	config Config
+	store  Store
+	events chan *eventbus.Event
+	queue  chan *delivery
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	mutex    sync.Mutex
+	closed   bool
+	retrying map[*delivery]*time.Timer
+}
+
+func NewDispatcher(store Store, config Config) *Dispatcher {
+	if config.Workers <= 0 {
+		config.Workers = DefaultWorkers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultMaxAttempts
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = DefaultInitialBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	config.MaxBackoff = max(config.MaxBackoff, config.InitialBackoff)
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{}
+	}
+	if config.Logger == nil {
+		config.Logger = logrus.StandardLogger()
+	}
+
+	d := &Dispatcher{
+		config:   config,
+		store:    store,
+		events:   make(chan *eventbus.Event, config.QueueSize),
+		queue:    make(chan *delivery, config.QueueSize),
+		done:     make(chan struct{}),
+		retrying: make(map[*delivery]*time.Timer),
+	}
+	d.wg.Add(config.Workers)
+	for i := 0; i < config.Workers; i++ {
+		go d.work()
+	}
+
+	return d
+}
+
+// Sign returns the value of SignatureHeader for the body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatch queues the event for every webhook subscribed to it. Only new posts
+// and comments are sent to webhooks, other events are ignored.
+func (d *Dispatcher) Dispatch(ctx context.Context, event *eventbus.Event) {
+	if _, ok := webhookEvent(event.Type); !ok {
+		return
+	}
+
+	d.mutex.Lock()
+	if !d.closed {
+		select {
+		case d.events <- event:
+			d.mutex.Unlock()
+			return
+		default:
+		}
+	}
+	d.mutex.Unlock()
+
+	// The dispatcher is closed or the event queue is full, the event is fanned
+	// out here so its deliveries are queued or saved as dead letters, not lost.
+	d.fanOut(event)
+}
+
+func webhookEvent(eventType eventbus.EventType) (model.WebhookEvent, bool) {
+	switch eventType {
+	case eventbus.PostAdded:
+		return model.WebhookEventPostAdded, true
+	case eventbus.CommentAdded:
+		return model.WebhookEventCommentAdded, true
+	default:
+		return "", false
+	}
+}
+
+// fanOut queues a delivery of the event for every webhook subscribed to it.
+func (d *Dispatcher) fanOut(event *eventbus.Event) {
+	webhookEvent, _ := webhookEvent(event.Type)
+	webhooks, err := d.store.GetWebhooks(context.Background())
+	if err != nil {
+		d.config.Logger.Errorf("error to get webhooks for %s event of post with id = %s: %v", webhookEvent, event.PostID, err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if !subscribed(webhook, webhookEvent, event.PostID) {
+			continue
+		}
+
+		payload := Payload{
+			ID:      uuid.New().String(),
+			Event:   webhookEvent,
+			PostID:  event.PostID,
+			Post:    event.Post,
+			Comment: event.Comment,
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			d.config.Logger.Errorf("error to encode %s event for webhook with id = %s: %v", webhookEvent, webhook.ID, err)
+			continue
+		}
+
+		d.enqueue(&delivery{id: payload.ID, webhook: webhook, event: webhookEvent, body: body})
+	}
+}
+
+// Close stops the workers and saves deliveries that are not done yet as dead letters.
+func (d *Dispatcher) Close() {
+	d.mutex.Lock()
+	d.closed = true
+	close(d.done)
+	var pending []*delivery
+	for delivery, timer := range d.retrying {
+		if timer.Stop() {
+			pending = append(pending, delivery)
+			d.wg.Done()
+		}
+	}
+	d.retrying = nil
+	d.mutex.Unlock()
+
+	// Waits for the workers and for the retries that have already fired, they
+	// may still be saving dead letters.
+	d.wg.Wait()
+
+	for _, delivery := range pending {
+		d.deadLetter(delivery, "dispatcher closed before delivery")
+	}
+	for {
+		select {
+		case event := <-d.events:
+			// enqueue saves the deliveries as dead letters, the dispatcher is closed.
+			d.fanOut(event)
+		case delivery := <-d.queue:
+			d.deadLetter(delivery, "dispatcher closed before delivery")
+		default:
+			return
+		}
+	}
+}
+
+func subscribed(webhook *model.Webhook, event model.WebhookEvent, postID string) bool {
+	if webhook.PostID != nil && *webhook.PostID != postID {
+		return false
+	}
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue queues the delivery or saves it as a dead letter. The dead letter is
+// written after the mutex is released, so a slow store does not block others.
+func (d *Dispatcher) enqueue(delivery *delivery) {
+	d.mutex.Lock()
+	reason := "dispatcher closed before delivery"
+	if !d.closed {
+		select {
+		case d.queue <- delivery:
+			d.mutex.Unlock()
+			return
+		default:
+			reason = "delivery queue is full"
+		}
+	}
+	d.mutex.Unlock()
+
+	d.deadLetter(delivery, reason)
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case event := <-d.events:
+			d.fanOut(event)
+		case delivery := <-d.queue:
+			d.attempt(delivery)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) attempt(delivery *delivery) {
+	delivery.attempts++
+	err := d.send(delivery)
+	if err == nil {
+		d.config.Logger.Infof("%s event delivered to webhook with id = %s", delivery.event, delivery.webhook.ID)
+		return
+	}
+	delivery.lastError = err.Error()
+
+	if delivery.attempts >= d.config.MaxAttempts {
+		d.deadLetter(delivery, delivery.lastError)
+		return
+	}
+
+	backoff := d.backoff(delivery.attempts)
+	d.config.Logger.Warnf("error to deliver %s event to webhook with id = %s, attempt %d, retry in %s: %v", delivery.event, delivery.webhook.ID, delivery.attempts, backoff, err)
+
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		d.deadLetter(delivery, delivery.lastError)
+		return
+	}
+	// Done is called by the retry or by Close if it stops the timer.
+	d.wg.Add(1)
+	d.retrying[delivery] = time.AfterFunc(backoff, func() {
+		defer d.wg.Done()
+
+		d.mutex.Lock()
+		delete(d.retrying, delivery)
+		d.mutex.Unlock()
+
+		// enqueue saves the delivery as a dead letter if Close came first.
+		d.enqueue(delivery)
+	})
+	d.mutex.Unlock()
+}
+
+func (d *Dispatcher) send(delivery *delivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.webhook.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, string(delivery.event))
+	request.Header.Set(DeliveryHeader, delivery.id)
+	request.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(SignatureHeader, Sign(delivery.webhook.Secret, timestamp, delivery.body))
+
+	response, err := d.config.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	// Reading the body lets the connection be reused.
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", response.Status)
+	}
+	return nil
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.config.InitialBackoff
+	for i := 1; i < attempts && backoff < d.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, d.config.MaxBackoff)
+}
+
+func (d *Dispatcher) deadLetter(delivery *delivery, lastError string) {
+	deadLetter := &model.WebhookDelivery{
+		ID:        delivery.id,
+		WebhookID: delivery.webhook.ID,
+		Event:     delivery.event,
+		Payload:   string(delivery.body),
+		Attempts:  delivery.attempts,
+		LastError: lastError,
+		FailedAt:  time.Now(),
+	}
+	if err := d.store.CreateDeadLetter(context.Background(), deadLetter); err != nil {
+		d.config.Logger.Errorf("error to save dead letter %s of webhook with id = %s: %v", delivery.id, delivery.webhook.ID, err)
+		return
+	}
+
+	d.config.Logger.Errorf("%s event is not delivered to webhook with id = %s after %d attempts: %s", delivery.event, delivery.webhook.ID, delivery.attempts, lastError)
+}
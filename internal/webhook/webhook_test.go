@@ -0,0 +1,222 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"postsandcomments/internal/db"
+	"postsandcomments/internal/eventbus"
+	"postsandcomments/internal/graph/model"
+	"postsandcomments/internal/webhook"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPostID = "test_post_id"
+
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver records requests and answers them with the statuses in order,
+// repeating the last one.
+type receiver struct {
+	mutex    sync.Mutex
+	statuses []int
+	requests []request
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mutex.Lock()
+	r.requests = append(r.requests, request{header: req.Header, body: body})
+	status := r.statuses[min(len(r.requests), len(r.statuses))-1]
+	r.mutex.Unlock()
+
+	w.WriteHeader(status)
+}
+
+func (r *receiver) received() []request {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]request(nil), r.requests...)
+}
+
+func setup(t *testing.T, config webhook.Config, statuses ...int) (*db.InMemoryDB, *model.Webhook, *receiver, *webhook.Dispatcher) {
+	recv := &receiver{statuses: statuses}
+	srv := httptest.NewServer(recv)
+	t.Cleanup(srv.Close)
+
+	store := db.NewInMemoryDB()
+	postID := testPostID
+	hook := &model.Webhook{
+		ID:     "test_webhook_id",
+		URL:    srv.URL,
+		Events: []model.WebhookEvent{model.WebhookEventCommentAdded},
+		PostID: &postID,
+		Secret: "test_secret",
+	}
+	require.NoError(t, store.CreateWebhook(context.Background(), hook))
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	config.Logger = logger
+	if config.InitialBackoff == 0 {
+		config.InitialBackoff = 10 * time.Millisecond
+	}
+
+	return store, hook, recv, webhook.NewDispatcher(store, config)
+}
+
+func commentAdded(postID string) *eventbus.Event {
+	return &eventbus.Event{
+		Type:    eventbus.CommentAdded,
+		PostID:  postID,
+		Comment: &model.Comment{ID: "test_comment_id", PostID: postID, Body: "Test Comment"},
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	_, hook, recv, dispatcher := setup(t, webhook.Config{}, http.StatusOK)
+	defer dispatcher.Close()
+
+	ctx := context.Background()
+	dispatcher.Dispatch(ctx, &eventbus.Event{Type: eventbus.PostAdded, PostID: testPostID, Post: &model.Post{ID: testPostID}})
+	dispatcher.Dispatch(ctx, commentAdded("other_post_id"))
+	dispatcher.Dispatch(ctx, &eventbus.Event{Type: eventbus.CommentUpdated, PostID: testPostID, Comment: &model.Comment{ID: "test_comment_id"}})
+	dispatcher.Dispatch(ctx, commentAdded(testPostID))
+
+	require.Eventually(t, func() bool { return len(recv.received()) > 0 }, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	requests := recv.received()
+	require.Len(t, requests, 1)
+
+	header := requests[0].header
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, string(model.WebhookEventCommentAdded), header.Get(webhook.EventHeader))
+	timestamp, err := strconv.ParseInt(header.Get(webhook.TimestampHeader), 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, webhook.Sign(hook.Secret, timestamp, requests[0].body), header.Get(webhook.SignatureHeader))
+	assert.NotEqual(t, webhook.Sign("wrong_secret", timestamp, requests[0].body), header.Get(webhook.SignatureHeader))
+
+	var payload webhook.Payload
+	require.NoError(t, json.Unmarshal(requests[0].body, &payload))
+	assert.Equal(t, header.Get(webhook.DeliveryHeader), payload.ID)
+	assert.Equal(t, model.WebhookEventCommentAdded, payload.Event)
+	assert.Equal(t, testPostID, payload.PostID)
+	require.NotNil(t, payload.Comment)
+	assert.Equal(t, "Test Comment", payload.Comment.Body)
+}
+
+func TestDispatchRetries(t *testing.T) {
+	store, _, recv, dispatcher := setup(t, webhook.Config{MaxAttempts: 5}, http.StatusInternalServerError, http.StatusBadGateway, http.StatusNoContent)
+	defer dispatcher.Close()
+
+	dispatcher.Dispatch(context.Background(), commentAdded(testPostID))
+
+	require.Eventually(t, func() bool { return len(recv.received()) == 3 }, time.Second, 5*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	requests := recv.received()
+	require.Len(t, requests, 3)
+	for _, req := range requests[1:] {
+		assert.Equal(t, requests[0].header.Get(webhook.DeliveryHeader), req.header.Get(webhook.DeliveryHeader))
+		assert.Equal(t, requests[0].body, req.body)
+	}
+
+	deadLetters, err := store.GetDeadLetters(context.Background(), nil, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
+
+func TestDispatchDeadLetter(t *testing.T) {
+	store, hook, recv, dispatcher := setup(t, webhook.Config{MaxAttempts: 3}, http.StatusInternalServerError)
+	defer dispatcher.Close()
+
+	dispatcher.Dispatch(context.Background(), commentAdded(testPostID))
+
+	var deadLetters []*model.WebhookDelivery
+	require.Eventually(t, func() bool {
+		var err error
+		deadLetters, err = store.GetDeadLetters(context.Background(), nil, 10)
+		require.NoError(t, err)
+		return len(deadLetters) > 0
+	}, time.Second, 5*time.Millisecond)
+
+	requests := recv.received()
+	require.Len(t, requests, 3)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, requests[0].header.Get(webhook.DeliveryHeader), deadLetters[0].ID)
+	assert.Equal(t, hook.ID, deadLetters[0].WebhookID)
+	assert.Equal(t, model.WebhookEventCommentAdded, deadLetters[0].Event)
+	assert.Equal(t, string(requests[0].body), deadLetters[0].Payload)
+	assert.Equal(t, 3, deadLetters[0].Attempts)
+	assert.Contains(t, deadLetters[0].LastError, "500")
+}
+
+func TestCloseSavesPendingDeliveries(t *testing.T) {
+	store, _, recv, dispatcher := setup(t, webhook.Config{InitialBackoff: time.Hour}, http.StatusServiceUnavailable)
+
+	dispatcher.Dispatch(context.Background(), commentAdded(testPostID))
+	require.Eventually(t, func() bool { return len(recv.received()) == 1 }, time.Second, 5*time.Millisecond)
+
+	// The delivery waits an hour for its retry, Close must not lose it.
+	time.Sleep(50 * time.Millisecond)
+	dispatcher.Close()
+
+	deadLetters, err := store.GetDeadLetters(context.Background(), nil, 10)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, 1, deadLetters[0].Attempts)
+
+	dispatcher.Dispatch(context.Background(), commentAdded(testPostID))
+	deadLetters, err = store.GetDeadLetters(context.Background(), nil, 10)
+	require.NoError(t, err)
+	assert.Len(t, deadLetters, 2)
+	assert.Len(t, recv.received(), 1)
+}
+
+// slowStore blocks GetWebhooks until release is closed.
+type slowStore struct {
+	webhook.Store
+	release chan struct{}
+}
+
+func (s *slowStore) GetWebhooks(ctx context.Context) ([]*model.Webhook, error) {
+	<-s.release
+	return s.Store.GetWebhooks(ctx)
+}
+
+func TestDispatchDoesNotWaitForStore(t *testing.T) {
+	store, _, recv, unused := setup(t, webhook.Config{}, http.StatusOK)
+	unused.Close()
+	slow := &slowStore{Store: store, release: make(chan struct{})}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	dispatcher := webhook.NewDispatcher(slow, webhook.Config{Logger: logger})
+	defer dispatcher.Close()
+
+	dispatched := make(chan struct{})
+	go func() {
+		dispatcher.Dispatch(context.Background(), commentAdded(testPostID))
+		close(dispatched)
+	}()
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("Dispatch waits for the webhooks to be loaded")
+	}
+
+	close(slow.release)
+	require.Eventually(t, func() bool { return len(recv.received()) == 1 }, time.Second, 5*time.Millisecond)
+}